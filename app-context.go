@@ -1,28 +1,215 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"service-bus-hero/config"
+	"service-bus-hero/topics"
+	"service-bus-hero/topics/local"
+)
 
 type AppContext struct {
+	Config           *config.Config
+	Profile          string
+	ReadOnly         bool
+	ProfileReadOnly  bool
+	Protected        bool
+	Confirmation     string
+	DryRun           bool
+	ArchiveDir       string
+	AuditLog         string
 	ConnectionString string
+	AuthMethod       topics.AuthMethod
+	Namespace        string
+	TenantID         string
+	ClientID         string
+	ClientSecret     string
+	Credentials      topics.Credentials
+	Client           *topics.Client
+	LocalDir         string
+	Local            *local.Namespace
+	Concurrency      int
+	EntityKind       topics.EntityKind
+	Queue            string
 	Topic            string
 	Subscription     string
 }
 
 func PrintContext(ctx *AppContext) {
-	fmt.Printf("Connection String: %s\n", ctx.ConnectionString)
-	fmt.Printf("Active topic: %s\n", ctx.Topic)
-	fmt.Printf("Active subscription: %s\n", ctx.Subscription)
+	if ctx.Profile != "" {
+		fmt.Printf("Profile: %s%s\n", ctx.Profile, profileFlags(ctx))
+	}
+	if ctx.ReadOnly {
+		fmt.Println("Read-only mode")
+	}
+	if ctx.DryRun {
+		fmt.Println("Dry run: resends, clears and publishing are only previewed")
+	}
+	if ctx.Local != nil {
+		fmt.Printf("Local namespace: %s\n", ctx.Local.Dir())
+	} else if ctx.AuthMethod == topics.AuthConnectionString {
+		fmt.Printf("Connection String: %s\n", ctx.ConnectionString)
+	} else {
+		fmt.Printf("Namespace: %s (%s)\n", ctx.Credentials.NamespaceName(), ctx.AuthMethod)
+	}
+	if ctx.EntityKind == topics.EntityKindQueue {
+		fmt.Printf("Active queue: %s\n", ctx.Queue)
+	} else {
+		fmt.Printf("Active topic: %s\n", ctx.Topic)
+		fmt.Printf("Active subscription: %s\n", ctx.Subscription)
+	}
+}
+
+func profileFlags(ctx *AppContext) string {
+	switch {
+	case ctx.ProfileReadOnly:
+		return " (read-only)"
+	case ctx.Protected:
+		return " (protected)"
+	default:
+		return ""
+	}
+}
+
+// Writable reports whether commands may send, resend or remove messages. Read-only mode and read-only
+// profiles do not apply to a local namespace.
+func (ctx *AppContext) Writable() bool {
+	return ctx.Local != nil || !(ctx.ReadOnly || ctx.ProfileReadOnly)
 }
 
 func (ctx *AppContext) SetConnectionString(connStr string) {
 	ctx.ConnectionString = connStr
+	ctx.AuthMethod = topics.AuthConnectionString
+}
+
+// ApplyProfile replaces the connection settings, the selected entity and the safety flags with those of
+// the profile. Credentials are built separately.
+func (ctx *AppContext) ApplyProfile(name string, profile config.Profile) error {
+	method, err := profile.AuthMethod()
+	if err != nil {
+		return fmt.Errorf("invalid profile %s: %w", name, err)
+	}
+
+	ctx.Profile = name
+	ctx.ProfileReadOnly = profile.ReadOnly
+	ctx.Protected = profile.Protected
+
+	ctx.ConnectionString = profile.ConnectionString
+	ctx.AuthMethod = method
+	ctx.Namespace = profile.Namespace
+	ctx.TenantID = profile.TenantID
+	ctx.ClientID = profile.ClientID
+	ctx.ClientSecret = profile.ClientSecret
+
+	ctx.Topic = profile.Topic
+	ctx.Subscription = profile.Subscription
+	ctx.Queue = profile.Queue
+	ctx.EntityKind = topics.EntityKindSubscription
+	if profile.Queue != "" && profile.Topic == "" {
+		ctx.EntityKind = topics.EntityKindQueue
+	}
+
+	return nil
 }
 
 func (ctx *AppContext) SetTopic(topic string) {
+	if ctx.Topic != topic {
+		ctx.Subscription = ""
+	}
 	ctx.Topic = topic
+	ctx.EntityKind = topics.EntityKindSubscription
+}
+
+func (ctx *AppContext) SetSubscription(subscription string) {
+	ctx.Subscription = subscription
+	ctx.EntityKind = topics.EntityKindSubscription
+}
+
+func (ctx *AppContext) SetQueue(queue string) {
+	ctx.Queue = queue
+	ctx.EntityKind = topics.EntityKindQueue
+}
+
+// Entity is the queue or topic subscription selected in the context.
+func (ctx *AppContext) Entity() topics.Entity {
+	if ctx.EntityKind == topics.EntityKindQueue {
+		return topics.NewQueueEntity(ctx.Queue)
+	}
+	return topics.NewSubscriptionEntity(ctx.Topic, ctx.Subscription)
+}
+
+// ResolveAuthMethod picks connection string auth when a connection string is known and the default
+// Azure AD credential chain when only a namespace is configured.
+func (ctx *AppContext) ResolveAuthMethod() {
+	if ctx.AuthMethod != "" {
+		return
+	}
+
+	if ctx.ConnectionString == "" && ctx.Namespace != "" {
+		ctx.AuthMethod = topics.AuthDefault
+	} else {
+		ctx.AuthMethod = topics.AuthConnectionString
+	}
+}
+
+func (ctx *AppContext) BuildCredentials() error {
+	creds, err := topics.NewCredentials(topics.AuthOptions{
+		Method:           ctx.AuthMethod,
+		ConnectionString: ctx.ConnectionString,
+		Namespace:        ctx.Namespace,
+		TenantID:         ctx.TenantID,
+		ClientID:         ctx.ClientID,
+		ClientSecret:     ctx.ClientSecret,
+	})
+	if err != nil {
+		return err
+	}
+
+	client, err := topics.NewClient(creds)
+	if err != nil {
+		return err
+	}
+
+	ctx.Close()
+
+	ctx.Credentials = creds
+	ctx.Client = client
+	ctx.LocalDir = ""
+
+	return nil
+}
+
+// OpenLocal works against the local namespace in LocalDir instead of a real one.
+func (ctx *AppContext) OpenLocal() error {
+	namespace, err := local.Open(ctx.LocalDir)
+	if err != nil {
+		return err
+	}
+
+	ctx.Close()
+
+	ctx.Local = namespace
+	ctx.Client = topics.NewClientWithBackend(namespace.Name(), namespace, namespace)
+
+	return nil
+}
+
+// Close releases the connection to the namespace, or saves the local namespace. It is safe to call when no client has been created.
+func (ctx *AppContext) Close() {
+	if err := ctx.Client.Close(context.Background()); err != nil {
+		fmt.Printf("Could not close service bus client: %v\n", err)
+	}
+
+	ctx.Client = nil
+	ctx.Local = nil
 }
 
 func (ctx *AppContext) Clear() {
+	ctx.Close()
 	ctx.ConnectionString = ""
+	ctx.Credentials = topics.Credentials{}
+	ctx.EntityKind = topics.EntityKindSubscription
+	ctx.Queue = ""
 	ctx.Topic = ""
+	ctx.Subscription = ""
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"path/filepath"
+	"service-bus-hero/io"
+	"service-bus-hero/topics"
+	"strings"
+	"time"
+)
+
+// dlqArchive streams the DLQ messages of one entity to a timestamped JSONL file in the archive directory
+// before they are resent or cleared. The file is created with the first batch, so nothing is left behind
+// for an empty DLQ, and can be published again to undo the operation.
+type dlqArchive struct {
+	fileName string
+	writer   *io.MessageWriter
+}
+
+func newDLQArchive(entity topics.Entity, operation string) *dlqArchive {
+	timestamp := time.Now().Format("20060102-150405.000")
+	name := fmt.Sprintf("%s-%s-%s.jsonl", strings.ReplaceAll(entity.String(), "/", "-"), timestamp, operation)
+
+	return &dlqArchive{fileName: filepath.Join(appContext.ArchiveDir, name)}
+}
+
+// write appends a batch to the archive and commits it to disk before the messages leave the DLQ.
+func (a *dlqArchive) write(messages []*azservicebus.ReceivedMessage) error {
+	if a.writer == nil {
+		writer, err := io.CreateMessageWriter(a.fileName)
+		if err != nil {
+			return err
+		}
+
+		a.writer = writer
+	}
+
+	for _, msg := range messages {
+		if err := a.writer.Write(msg); err != nil {
+			return err
+		}
+	}
+
+	return a.writer.Sync()
+}
+
+// close closes the archive file and reports where the messages were archived.
+func (a *dlqArchive) close() {
+	if a.writer == nil {
+		return
+	}
+
+	if err := a.writer.Close(); err != nil {
+		fmt.Printf("Could not close archive %s: %v\n", a.fileName, err)
+		return
+	}
+
+	fmt.Printf("Archived %d messages to %s\n", a.writer.Count(), a.fileName)
+}
+
+// archivedResendOptions returns a copy of options that archives every batch to archive before it is resent.
+func archivedResendOptions(options *topics.ResendOptions, archive *dlqArchive) *topics.ResendOptions {
+	archived := topics.ResendOptions{}
+	if options != nil {
+		archived = *options
+	}
+
+	archived.Archive = archive.write
+
+	return &archived
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"os"
+	"service-bus-hero/audit"
+	"sync"
+)
+
+// auditRecord logs a command that removes, resends or publishes messages to the audit log. The started
+// entry is written before anything is changed, so a command that cannot be audited does not run.
+type auditRecord struct {
+	log     *audit.Log
+	started audit.Entry
+	details map[string]string
+
+	mu         sync.Mutex
+	messageIDs []string
+}
+
+func auditLogPath() (string, error) {
+	if appContext.AuditLog != "" {
+		return appContext.AuditLog, nil
+	}
+	return audit.DefaultPath()
+}
+
+func startAudit(command string, entity string, details map[string]string) (*auditRecord, error) {
+	path, err := auditLogPath()
+	if err != nil {
+		return nil, err
+	}
+
+	record := &auditRecord{log: audit.Open(path), details: make(map[string]string)}
+	for key, value := range details {
+		if value != "" {
+			record.details[key] = value
+		}
+	}
+
+	record.started, err = record.log.Append(audit.Entry{
+		Event:     audit.EventStarted,
+		Command:   command,
+		Profile:   appContext.Profile,
+		Namespace: appContext.Client.NamespaceName(),
+		Entity:    entity,
+		Details:   record.details,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not write audit log, nothing was changed: %w", err)
+	}
+
+	return record, nil
+}
+
+func (r *auditRecord) addMessages(messages []*azservicebus.ReceivedMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, msg := range messages {
+		r.messageIDs = append(r.messageIDs, msg.MessageID)
+	}
+}
+
+func (r *auditRecord) addMessageID(id *string) {
+	if id == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messageIDs = append(r.messageIDs, *id)
+}
+
+// addSentMessages records the IDs of published messages. Service Bus assigns an ID to a message sent
+// without one, which is not known here.
+func (r *auditRecord) addSentMessages(messages []*azservicebus.Message) {
+	for _, msg := range messages {
+		r.addMessageID(msg.MessageID)
+	}
+}
+
+// tee records the ID of every message passing through.
+func (r *auditRecord) tee(messageChan <-chan *azservicebus.ReceivedMessage) <-chan *azservicebus.ReceivedMessage {
+	out := make(chan *azservicebus.ReceivedMessage)
+
+	go func() {
+		defer close(out)
+		for msg := range messageChan {
+			r.addMessageID(&msg.MessageID)
+			out <- msg
+		}
+	}()
+
+	return out
+}
+
+// finish logs how many messages the command affected and how it ended, and returns err. When the entry
+// cannot be written the messages have been changed already, so the failure is reported along with err.
+func (r *auditRecord) finish(count int, err error) error {
+	r.mu.Lock()
+	messageIDs := r.messageIDs
+	r.mu.Unlock()
+
+	_, auditErr := r.log.Append(audit.Entry{
+		Event:      audit.EventFinished,
+		Operation:  r.started.Seq,
+		Command:    r.started.Command,
+		Profile:    r.started.Profile,
+		Namespace:  r.started.Namespace,
+		Entity:     r.started.Entity,
+		Details:    r.details,
+		Messages:   count,
+		MessageIDs: messageIDs,
+		Outcome:    outcome(err),
+	})
+	if auditErr == nil {
+		return err
+	}
+
+	auditErr = fmt.Errorf("could not write audit log: %w", auditErr)
+	if err == nil {
+		return auditErr
+	}
+
+	fmt.Fprintln(os.Stderr, auditErr)
+	return err
+}
+
+// VerifyAuditLog checks the hash chain of the audit log.
+func VerifyAuditLog() error {
+	path, err := auditLogPath()
+	if err != nil {
+		return err
+	}
+
+	result, err := audit.Verify(path)
+	if err != nil {
+		return fmt.Errorf("audit log %s is not intact after %d entries: %w", path, result.Entries, err)
+	}
+
+	fmt.Printf("Audit log %s is intact: %d entries, last hash %s\n", path, result.Entries, result.Head)
+	return nil
+}
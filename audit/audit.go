@@ -0,0 +1,279 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+// PathEnv overrides where the audit log is written to.
+const PathEnv = "SBHERO_AUDIT_LOG"
+
+const (
+	// EventStarted is logged before a command changes anything, EventFinished once it is done.
+	EventStarted  = "started"
+	EventFinished = "finished"
+)
+
+// lockTimeout is how long Append waits for another process writing to the same log.
+const lockTimeout = 10 * time.Second
+
+// Entry is one line of the audit log. Every entry holds the hash of the entry before it, and its own hash
+// covers all of its fields, so changing, inserting or removing a line breaks the chain from there on.
+type Entry struct {
+	Seq       int64             `json:"seq"`
+	Time      time.Time         `json:"time"`
+	Event     string            `json:"event"`
+	Operation int64             `json:"operation"`
+	User      string            `json:"user"`
+	Host      string            `json:"host"`
+	Command   string            `json:"command"`
+	Profile   string            `json:"profile,omitempty"`
+	Namespace string            `json:"namespace"`
+	Entity    string            `json:"entity,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	// Messages is how many messages were removed, resent or published; it is only set on finished entries.
+	Messages   int      `json:"messages"`
+	MessageIDs []string `json:"messageIds,omitempty"`
+	Outcome    string   `json:"outcome,omitempty"`
+	PrevHash   string   `json:"prevHash"`
+	Hash       string   `json:"hash,omitempty"`
+}
+
+// computeHash hashes the JSON encoding of the entry without its own hash.
+func (e Entry) computeHash() (string, error) {
+	e.Hash = ""
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("could not encode audit entry: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// DefaultPath returns the path of the audit log: SBHERO_AUDIT_LOG when set, and sbhero/audit.jsonl in the
+// user config directory otherwise.
+func DefaultPath() (string, error) {
+	if path := os.Getenv(PathEnv); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find user config directory: %w", err)
+	}
+
+	return filepath.Join(dir, "sbhero", "audit.jsonl"), nil
+}
+
+// Log is an append-only JSON lines file of hash-chained entries.
+type Log struct {
+	path string
+}
+
+func Open(path string) *Log {
+	return &Log{path: path}
+}
+
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Append numbers entry, chains it to the last entry of the log, stamps it with the current user and host,
+// and writes it to disk before returning it. Operation is set to the entry's own number when it is 0.
+func (l *Log) Append(entry Entry) (Entry, error) {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return entry, fmt.Errorf("could not create audit log directory: %w", err)
+	}
+
+	unlock, err := l.lock()
+	if err != nil {
+		return entry, err
+	}
+	defer unlock()
+
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return entry, fmt.Errorf("could not open audit log: %w", err)
+	}
+	defer file.Close()
+
+	last, err := lastEntry(file)
+	if err != nil {
+		return entry, err
+	}
+
+	entry.Seq = 1
+	if last != nil {
+		entry.Seq = last.Seq + 1
+		entry.PrevHash = last.Hash
+	}
+	if entry.Operation == 0 {
+		entry.Operation = entry.Seq
+	}
+	entry.Time = time.Now().UTC()
+	entry.User = currentUser()
+	entry.Host, _ = os.Hostname()
+
+	entry.Hash, err = entry.computeHash()
+	if err != nil {
+		return entry, err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return entry, fmt.Errorf("could not encode audit entry: %w", err)
+	}
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return entry, fmt.Errorf("could not write audit log: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return entry, fmt.Errorf("could not sync audit log: %w", err)
+	}
+
+	return entry, nil
+}
+
+// lock keeps other processes from appending at the same time, which would fork the chain.
+func (l *Log) lock() (func(), error) {
+	lockPath := l.path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("could not lock audit log: %w", err)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("audit log is locked, remove %s if no other sbhero process is running", lockPath)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// lastEntry reads the last line of the log by scanning backwards from its end.
+func lastEntry(file *os.File) (*Entry, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("could not read audit log: %w", err)
+	}
+
+	const chunkSize = 64 * 1024
+	var tail []byte
+
+	for offset := size; offset > 0; {
+		n := min(int64(chunkSize), offset)
+		offset -= n
+
+		chunk := make([]byte, n)
+		if _, err := file.ReadAt(chunk, offset); err != nil {
+			return nil, fmt.Errorf("could not read audit log: %w", err)
+		}
+		tail = append(chunk, tail...)
+
+		// The line is complete once a newline is found before it
+		trimmed := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 || offset == 0 {
+			line := trimmed[i+1:]
+			if len(line) == 0 {
+				return nil, nil
+			}
+
+			var entry Entry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return nil, fmt.Errorf("could not parse last audit log entry: %w", err)
+			}
+			return &entry, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// VerifyResult summarises an intact log. Head is the hash of the last entry; keeping a copy of it elsewhere
+// also makes removing entries from the end of the log detectable.
+type VerifyResult struct {
+	Entries int
+	Head    string
+}
+
+// Verify checks the numbering and hash chain of every entry of the log at path and reports the first line
+// that does not match.
+func Verify(path string) (VerifyResult, error) {
+	var result VerifyResult
+
+	file, err := os.Open(path)
+	if err != nil {
+		return result, fmt.Errorf("could not open audit log: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	prevHash := ""
+
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var entry Entry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return result, fmt.Errorf("line %d: could not parse entry: %w", lineNumber, err)
+			}
+
+			if entry.Seq != int64(result.Entries)+1 {
+				return result, fmt.Errorf("line %d: entry %d found where entry %d was expected", lineNumber, entry.Seq, result.Entries+1)
+			}
+			if entry.PrevHash != prevHash {
+				return result, fmt.Errorf("line %d: entry %d does not follow the entry before it", lineNumber, entry.Seq)
+			}
+
+			hash, err := entry.computeHash()
+			if err != nil {
+				return result, err
+			}
+			if hash != entry.Hash {
+				return result, fmt.Errorf("line %d: entry %d has been modified", lineNumber, entry.Seq)
+			}
+
+			result.Entries++
+			result.Head = entry.Hash
+			prevHash = entry.Hash
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("could not read audit log: %w", err)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,265 @@
+package check
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"service-bus-hero/stats"
+	"strconv"
+	"strings"
+)
+
+// operators are tried in order, so two-character operators have to come before their one-character prefixes.
+var operators = []string{"==", "!=", ">=", "<=", ">", "<"}
+
+type metric struct {
+	name  string
+	kinds []stats.Kind
+	value func(stats.Row) int64
+}
+
+var metrics = []metric{
+	{"active", []stats.Kind{stats.KindSubscription, stats.KindQueue}, func(r stats.Row) int64 { return int64(r.ActiveMessages) }},
+	{"dlq", []stats.Kind{stats.KindSubscription, stats.KindQueue}, func(r stats.Row) int64 { return int64(r.DeadLetterMessages) }},
+	{"transferdlq", []stats.Kind{stats.KindSubscription, stats.KindQueue}, func(r stats.Row) int64 { return int64(r.TransferDeadLetterMessages) }},
+	{"scheduled", []stats.Kind{stats.KindTopic, stats.KindQueue}, func(r stats.Row) int64 { return int64(r.ScheduledMessages) }},
+	{"size", []stats.Kind{stats.KindTopic, stats.KindQueue}, func(r stats.Row) int64 { return r.SizeInBytes }},
+}
+
+// Rule is a threshold on one metric of every entity matching a pattern, for example `dlq(orders/*) == 0`
+// or `active(billing/invoice-sub) < 10000`. Subscriptions are matched as "topic/subscription", queues and
+// topics by their name; patterns use path.Match syntax.
+type Rule struct {
+	expression string
+	metric     metric
+	pattern    string
+	operator   string
+	threshold  int64
+}
+
+func Parse(expression string) (Rule, error) {
+	expression = strings.TrimSpace(expression)
+	rule := Rule{expression: expression}
+
+	open := strings.Index(expression, "(")
+	closing := strings.LastIndex(expression, ")")
+	if open <= 0 || closing < open {
+		return Rule{}, fmt.Errorf("invalid rule %q, expected <metric>(<pattern>) <operator> <value>", expression)
+	}
+
+	name := strings.ToLower(strings.TrimSpace(expression[:open]))
+	found := false
+	for _, m := range metrics {
+		if m.name == name {
+			rule.metric = m
+			found = true
+			break
+		}
+	}
+	if !found {
+		return Rule{}, fmt.Errorf("unknown metric %q in rule %q, expected one of %s", name, expression, strings.Join(MetricNames(), ", "))
+	}
+
+	rule.pattern = strings.TrimSpace(expression[open+1 : closing])
+	if _, err := path.Match(rule.pattern, ""); err != nil || rule.pattern == "" {
+		return Rule{}, fmt.Errorf("invalid pattern %q in rule %q", rule.pattern, expression)
+	}
+
+	comparison := strings.TrimSpace(expression[closing+1:])
+	for _, op := range operators {
+		if value, ok := strings.CutPrefix(comparison, op); ok {
+			rule.operator = op
+			comparison = strings.TrimSpace(value)
+			break
+		}
+	}
+	if rule.operator == "" {
+		return Rule{}, fmt.Errorf("missing operator in rule %q, expected one of %s", expression, strings.Join(operators, " "))
+	}
+
+	threshold, err := strconv.ParseInt(comparison, 10, 64)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid value %q in rule %q", comparison, expression)
+	}
+	rule.threshold = threshold
+
+	return rule, nil
+}
+
+func MetricNames() []string {
+	names := make([]string, len(metrics))
+	for i, m := range metrics {
+		names[i] = m.name
+	}
+	return names
+}
+
+func (r Rule) String() string {
+	return r.expression
+}
+
+func (r Rule) holds(value int64) bool {
+	switch r.operator {
+	case "==":
+		return value == r.threshold
+	case "!=":
+		return value != r.threshold
+	case ">":
+		return value > r.threshold
+	case ">=":
+		return value >= r.threshold
+	case "<":
+		return value < r.threshold
+	case "<=":
+		return value <= r.threshold
+	}
+	return false
+}
+
+// Violation is an entity whose metric breaks a rule.
+type Violation struct {
+	Entity string
+	Value  int64
+}
+
+// Result is the outcome of one rule. A rule whose pattern matches no entity fails, so a typo in a
+// pattern cannot make a check pass silently.
+type Result struct {
+	Rule       Rule
+	Matched    int
+	Violations []Violation
+	// Errors lists entities that match the pattern but whose stats could not be fetched.
+	Errors []string
+}
+
+func (r Result) Passed() bool {
+	return r.Matched > 0 && len(r.Violations) == 0 && len(r.Errors) == 0
+}
+
+// Failed reports whether the rule is known to be violated. A rule that holds for the entities it matched
+// but could not be evaluated for all of them has neither passed nor failed.
+func (r Result) Failed() bool {
+	return len(r.Violations) > 0 || r.Matched == 0 && len(r.Errors) == 0
+}
+
+type Report struct {
+	Results []Result
+}
+
+func Evaluate(rules []Rule, snapshot *stats.Snapshot) Report {
+	var report Report
+
+	for _, rule := range rules {
+		result := Result{Rule: rule}
+
+		for _, row := range snapshot.Rows {
+			// A topic whose subscriptions could not be listed may hide subscriptions the rule covers
+			if row.Error != "" {
+				if matchesTopic(rule.pattern, row.Topic) {
+					result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", row.Topic, row.Error))
+				}
+				continue
+			}
+
+			if !rule.appliesTo(row.Kind) {
+				continue
+			}
+
+			name := entityName(row)
+			if ok, _ := path.Match(rule.pattern, name); !ok {
+				continue
+			}
+
+			result.Matched++
+
+			value := rule.metric.value(row)
+			if !rule.holds(value) {
+				result.Violations = append(result.Violations, Violation{Entity: name, Value: value})
+			}
+		}
+
+		report.Results = append(report.Results, result)
+	}
+
+	return report
+}
+
+func (r Report) Failed() int {
+	failed := 0
+	for _, result := range r.Results {
+		if result.Failed() {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Errored returns how many rules could not be evaluated for every entity they match.
+func (r Report) Errored() int {
+	errored := 0
+	for _, result := range r.Results {
+		if len(result.Errors) > 0 {
+			errored++
+		}
+	}
+	return errored
+}
+
+// Write prints a line per rule and an indented line per violating entity.
+func (r Report) Write(w io.Writer) {
+	for _, result := range r.Results {
+		status := "PASS"
+		if result.Failed() {
+			status = "FAIL"
+		} else if !result.Passed() {
+			status = "ERROR"
+		}
+
+		fmt.Fprintf(w, "%s  %s  (%d matched)\n", status, result.Rule, result.Matched)
+
+		if result.Matched == 0 && len(result.Errors) == 0 {
+			fmt.Fprintln(w, "      no entity matches the pattern")
+		}
+
+		for _, violation := range result.Violations {
+			fmt.Fprintf(w, "      %s: %s is %d\n", violation.Entity, result.Rule.metric.name, violation.Value)
+		}
+
+		for _, err := range result.Errors {
+			fmt.Fprintf(w, "      error: %s\n", err)
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d of %d checks failed", r.Failed(), len(r.Results))
+	if errored := r.Errored(); errored > 0 {
+		fmt.Fprintf(w, ", %d could not be evaluated", errored)
+	}
+	fmt.Fprintln(w)
+}
+
+func (r Rule) appliesTo(kind stats.Kind) bool {
+	for _, k := range r.metric.kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesTopic reports whether pattern can match the topic or one of its subscriptions.
+func matchesTopic(pattern string, topic string) bool {
+	topicPattern, _, _ := strings.Cut(pattern, "/")
+	ok, _ := path.Match(topicPattern, topic)
+	return ok
+}
+
+func entityName(row stats.Row) string {
+	switch row.Kind {
+	case stats.KindQueue:
+		return row.Queue
+	case stats.KindTopic:
+		return row.Topic
+	default:
+		return row.Topic + "/" + row.Subscription
+	}
+}
@@ -0,0 +1,53 @@
+package check
+
+import (
+	"service-bus-hero/stats"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	snapshot := &stats.Snapshot{Rows: []stats.Row{
+		{Kind: stats.KindTopic, Topic: "orders"},
+		{Kind: stats.KindSubscription, Topic: "orders", Subscription: "billing", DeadLetterMessages: 3},
+		{Kind: stats.KindSubscription, Topic: "orders", Subscription: "audit"},
+		{Kind: stats.KindTopic, Topic: "invoices", Error: "could not list subscriptions"},
+		{Kind: stats.KindQueue, Queue: "payments", ActiveMessages: 10},
+	}}
+
+	tests := []struct {
+		rule        string
+		wantPassed  bool
+		wantFailed  bool
+		wantErrored bool
+	}{
+		{"dlq(orders/audit) == 0", true, false, false},
+		{"dlq(orders/*) == 0", false, true, false},
+		{"active(payments) < 100", true, false, false},
+		{"active(missing) == 0", false, true, false},
+		{"dlq(invoices/*) == 0", false, false, true},
+		{"dlq(payments) >= 0", true, false, false},
+		{"dlq(*/*) == 0", false, true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.rule, func(t *testing.T) {
+			rule, err := Parse(test.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			report := Evaluate([]Rule{rule}, snapshot)
+			result := report.Results[0]
+
+			if result.Passed() != test.wantPassed {
+				t.Errorf("Passed() = %v, want %v", result.Passed(), test.wantPassed)
+			}
+			if result.Failed() != test.wantFailed {
+				t.Errorf("Failed() = %v, want %v", result.Failed(), test.wantFailed)
+			}
+			if errored := report.Errored() > 0; errored != test.wantErrored {
+				t.Errorf("Errored() > 0 = %v, want %v", errored, test.wantErrored)
+			}
+		})
+	}
+}
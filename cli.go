@@ -0,0 +1,685 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/spf13/cobra"
+	"os"
+	"service-bus-hero/check"
+	"service-bus-hero/filter"
+	"service-bus-hero/io"
+	"service-bus-hero/stats"
+	"service-bus-hero/topics"
+	"strings"
+	"time"
+)
+
+const (
+	exitCodeCheckError = 2
+	// exitCodeInterrupted is what shells report for a process ended by Ctrl+C.
+	exitCodeInterrupted = 130
+)
+
+// errChecksFailed is returned when a check rule is violated; the process exits with 1 as for any other error.
+var errChecksFailed = errors.New("checks failed")
+
+// exitError makes the process exit with code instead of 1.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// exitCode maps an error returned by a command to the process exit code.
+func exitCode(err error) int {
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	if errors.Is(err, topics.ErrInterrupted) {
+		return exitCodeInterrupted
+	}
+	return 1
+}
+
+type entityFlags struct {
+	queue        string
+	topic        string
+	subscription string
+}
+
+func (f *entityFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&f.queue, "queue", "q", "", "queue to work with")
+	cmd.Flags().StringVarP(&f.topic, "topic", "t", "", "topic to work with")
+	cmd.Flags().StringVarP(&f.subscription, "subscription", "s", "", "subscription to work with")
+	cmd.MarkFlagsMutuallyExclusive("queue", "topic")
+	cmd.MarkFlagsMutuallyExclusive("queue", "subscription")
+}
+
+// apply copies the flags into the app context, leaving values loaded from the environment untouched when a flag is not set.
+func (f *entityFlags) apply() {
+	if f.queue != "" {
+		appContext.SetQueue(f.queue)
+	}
+
+	if f.topic != "" {
+		appContext.SetTopic(f.topic)
+	}
+
+	if f.subscription != "" {
+		appContext.SetSubscription(f.subscription)
+	}
+}
+
+type authFlags struct {
+	connectionString string
+	method           string
+	namespace        string
+	tenantID         string
+	clientID         string
+}
+
+func (f *authFlags) register(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&f.connectionString, "connection-string", "", "service bus connection string (defaults to SBHERO_CONNECTION_STRING)")
+	cmd.PersistentFlags().StringVar(&f.method, "auth", "", "auth method: connection-string, default, managed-identity, service-principal or az-cli (defaults to SBHERO_AUTH)")
+	cmd.PersistentFlags().StringVar(&f.namespace, "namespace", "", "namespace FQDN for Azure AD auth (defaults to SBHERO_NAMESPACE)")
+	cmd.PersistentFlags().StringVar(&f.tenantID, "tenant-id", "", "Azure AD tenant ID (defaults to SBHERO_TENANT_ID)")
+	cmd.PersistentFlags().StringVar(&f.clientID, "client-id", "", "client ID of the service principal or user-assigned managed identity (defaults to SBHERO_CLIENT_ID)")
+}
+
+// connects reports whether the flags configure a connection on their own.
+func (f *authFlags) connects() bool {
+	return f.connectionString != "" || f.namespace != ""
+}
+
+func (f *authFlags) apply() error {
+	if f.connectionString != "" {
+		appContext.SetConnectionString(f.connectionString)
+	}
+
+	if f.namespace != "" {
+		appContext.Namespace = f.namespace
+	}
+
+	if f.tenantID != "" {
+		appContext.TenantID = f.tenantID
+	}
+
+	if f.clientID != "" {
+		appContext.ClientID = f.clientID
+	}
+
+	if f.method != "" {
+		method, err := topics.ParseAuthMethod(f.method)
+		if err != nil {
+			return err
+		}
+
+		appContext.AuthMethod = method
+	}
+
+	return nil
+}
+
+type overrideFlags struct {
+	strip []string
+	set   []string
+}
+
+func (f *overrideFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&f.strip, "strip", nil, "message properties to remove before sending, e.g. messageID,scheduledEnqueueTime or app.<name>; messages without a messageID get a new one, which resending to a queue or topic with duplicate detection requires")
+	cmd.Flags().StringArrayVar(&f.set, "set", nil, "message property to replace before sending, as name=value (repeatable); a messageID set to one value for every message is dropped as a duplicate by queues and topics with duplicate detection")
+}
+
+func (f *overrideFlags) parse() (*io.MessageOverrides, error) {
+	return io.ParseMessageOverrides(f.strip, f.set)
+}
+
+func newRootCommand() *cobra.Command {
+	var auth authFlags
+	var profile string
+
+	root := &cobra.Command{
+		Use:          "sbhero",
+		Short:        "A tool for monitoring and managing messages in Azure Service Bus namespace.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := LoadConfig(profile, !auth.connects() && appContext.LocalDir == ""); err != nil {
+				return err
+			}
+
+			if err := auth.apply(); err != nil {
+				return err
+			}
+
+			return GetCredentials()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runMenu()
+		},
+	}
+
+	auth.register(root)
+	root.PersistentFlags().StringVarP(&profile, "profile", "p", "", "profile of the config file to use (defaults to SBHERO_PROFILE, then the default profile)")
+	root.PersistentFlags().BoolVar(&appContext.ReadOnly, "read-only", false, "refuse every command that publishes, resends or removes messages")
+	root.PersistentFlags().StringVar(&appContext.ArchiveDir, "archive-dir", "dlq-archive", "directory DLQ messages are archived to before they are resent or cleared")
+	root.PersistentFlags().StringVar(&appContext.AuditLog, "audit-log", "", "audit log of commands that change messages (defaults to SBHERO_AUDIT_LOG, then sbhero/audit.jsonl in the user config directory)")
+	root.PersistentFlags().StringVar(&appContext.LocalDir, "local", "", "work against the local namespace stored in this directory instead of Service Bus")
+	root.PersistentFlags().IntVar(&appContext.Concurrency, "concurrency", topics.DefaultConcurrency, "number of topics whose subscription stats are fetched in parallel")
+
+	root.AddCommand(
+		newStatsCommand(),
+		newWatchCommand(),
+		newServeCommand(),
+		newCheckCommand(),
+		newDLQCommand(),
+		newBrowseCommand(),
+		newPublishCommand(),
+		newSelectCommand(),
+		newLocalCommand(),
+		newAuditCommand(),
+	)
+
+	return root
+}
+
+func newStatsCommand() *cobra.Command {
+	var queues bool
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "List stats for all topics.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if queues {
+				return ListQueueStats(cmd.Context(), output)
+			}
+
+			return ListTopicStatByTopics(cmd.Context(), output)
+		},
+	}
+
+	cmd.Flags().BoolVar(&queues, "queues", false, "list stats for all queues instead")
+	registerOutputFlag(cmd, &output)
+
+	return cmd
+}
+
+func newWatchCommand() *cobra.Command {
+	var interval time.Duration
+	var dlqOnly bool
+
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Refreshes subscription and queue stats in place, highlighting changes.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if interval <= 0 {
+				return errors.New("--interval must be positive")
+			}
+
+			return WatchStats(cmd.Context(), interval, dlqOnly)
+		},
+	}
+
+	cmd.Flags().DurationVarP(&interval, "interval", "i", 10*time.Second, "how often stats are refreshed")
+	cmd.Flags().BoolVar(&dlqOnly, "dlq", false, "only show subscriptions and queues with DLQ messages")
+
+	return cmd
+}
+
+func newServeCommand() *cobra.Command {
+	var addr string
+	var maxAge time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serves subscription, topic and queue stats as Prometheus metrics on /metrics.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ServeMetrics(cmd.Context(), addr, maxAge)
+		},
+	}
+
+	cmd.Flags().StringVar(&addr, "listen", ":9090", "address to listen on")
+	cmd.Flags().DurationVar(&maxAge, "cache", 30*time.Second, "how long collected stats are reused for further scrapes")
+
+	return cmd
+}
+
+func newCheckCommand() *cobra.Command {
+	var fileName string
+
+	cmd := &cobra.Command{
+		Use:   "check [rule...]",
+		Short: "Checks stats against threshold rules, exiting with 1 when a rule is violated and 2 on errors.",
+		Long: `Checks stats against threshold rules such as "dlq(orders/*) == 0" or "active(billing/invoice-sub) < 10000".
+
+A rule is <metric>(<pattern>) <operator> <value>. Metrics are ` + strings.Join(check.MetricNames(), ", ") + `.
+Subscriptions are matched as topic/subscription, queues and topics by name, and * and ? can be used as wildcards.
+Operators are ==, !=, <, <=, > and >=. A rule whose pattern matches nothing fails.
+
+The command exits with 0 when every rule holds, 1 when a rule is violated and 2 when the check could not be run.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Connecting is part of running the check, so failing to connect is not a violation
+			if err := cmd.Root().PersistentPreRunE(cmd, args); err != nil {
+				return &exitError{code: exitCodeCheckError, err: err}
+			}
+
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rules := args
+
+			if fileName != "" {
+				fileRules, err := readRulesFile(fileName)
+				if err != nil {
+					return &exitError{code: exitCodeCheckError, err: err}
+				}
+				rules = append(rules, fileRules...)
+			}
+
+			err := RunChecks(cmd.Context(), rules)
+			if err == nil || errors.Is(err, errChecksFailed) {
+				return err
+			}
+
+			return &exitError{code: exitCodeCheckError, err: err}
+		},
+	}
+
+	cmd.Flags().StringVarP(&fileName, "file", "f", "", "file with one rule per line; blank lines and lines starting with # are ignored")
+
+	return cmd
+}
+
+// readRulesFile reads one check rule per line.
+func readRulesFile(fileName string) ([]string, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("could not read rules file: %w", err)
+	}
+
+	var rules []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rules = append(rules, line)
+	}
+
+	return rules, nil
+}
+
+func newDLQCommand() *cobra.Command {
+	dlq := &cobra.Command{
+		Use:   "dlq",
+		Short: "Work with dead-letter queues.",
+	}
+
+	dlq.AddCommand(
+		newDLQStatsCommand(),
+		newDLQDownloadCommand(),
+		newDLQResendCommand(),
+		newDLQClearCommand(),
+	)
+
+	return dlq
+}
+
+func newDLQStatsCommand() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "List stats for subscriptions and queues with DLQ messages.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ListDLQStats(cmd.Context(), output)
+		},
+	}
+
+	registerOutputFlag(cmd, &output)
+
+	return cmd
+}
+
+func newDLQDownloadCommand() *cobra.Command {
+	var entity entityFlags
+	var fileName string
+	var receiveMode string
+	var filterExpression string
+
+	cmd := &cobra.Command{
+		Use:   "download",
+		Short: "Downloads DLQ messages of a queue or subscription to a JSONL file.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			messageFilter, err := parseFilter(filterExpression)
+			if err != nil {
+				return err
+			}
+
+			if strings.ToLower(receiveMode) == "peek" {
+				entity.apply()
+
+				return BrowseMessagesToFile(cmd.Context(), true, fileName, messageFilter)
+			}
+
+			mode, err := parseReceiveMode(receiveMode)
+			if err != nil {
+				return err
+			}
+
+			entity.apply()
+
+			return WriteDLQMessagesToFile(cmd.Context(), mode, fileName, messageFilter)
+		},
+	}
+
+	entity.register(cmd)
+	cmd.Flags().StringVarP(&fileName, "file", "f", "", "file to write messages to")
+	cmd.Flags().StringVarP(&receiveMode, "receive-mode", "m", "peek", "receive mode: peek (no locks), peeklock or receiveanddelete")
+	cmd.Flags().StringVar(&filterExpression, "filter", "", "only download messages matching the expression, e.g. \"reason=MaxDeliveryCountExceeded, enqueued>=-2h\"")
+	registerConfirmFlag(cmd)
+
+	return cmd
+}
+
+func newBrowseCommand() *cobra.Command {
+	var entity entityFlags
+	var fileName string
+	var deadLetter bool
+	var filterExpression string
+
+	cmd := &cobra.Command{
+		Use:   "browse",
+		Short: "Downloads messages of a queue or subscription to a JSONL file without locking them.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			messageFilter, err := parseFilter(filterExpression)
+			if err != nil {
+				return err
+			}
+
+			entity.apply()
+
+			return BrowseMessagesToFile(cmd.Context(), deadLetter, fileName, messageFilter)
+		},
+	}
+
+	entity.register(cmd)
+	cmd.Flags().StringVarP(&fileName, "file", "f", "", "file to write messages to")
+	cmd.Flags().BoolVar(&deadLetter, "dlq", false, "browse the dead-letter queue instead of active messages")
+	cmd.Flags().StringVar(&filterExpression, "filter", "", "only download messages matching the expression, e.g. \"subject^=Order\"")
+
+	return cmd
+}
+
+func newDLQResendCommand() *cobra.Command {
+	var entity entityFlags
+	var all bool
+	var redelivery string
+	var overrides overrideFlags
+
+	cmd := &cobra.Command{
+		Use:   "resend",
+		Short: "Resends DLQ messages of a queue or subscription back to its queue or topic.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mode, err := topics.ParseRedeliveryMode(redelivery)
+			if err != nil {
+				return err
+			}
+
+			messageOverrides, err := overrides.parse()
+			if err != nil {
+				return err
+			}
+
+			options := &topics.ResendOptions{Redelivery: mode, Overrides: messageOverrides}
+
+			if all {
+				return ResendAllDLQMessages(cmd.Context(), options)
+			}
+
+			if err := requireEntityFlags(entity); err != nil {
+				return err
+			}
+
+			entity.apply()
+
+			return ResendDLQMessages(cmd.Context(), options)
+		},
+	}
+
+	entity.register(cmd)
+	cmd.Flags().BoolVar(&all, "all", false, "resend DLQ messages from all subscriptions and queues")
+	overrides.register(cmd)
+	cmd.Flags().StringVar(&redelivery, "redelivery", "topic", "where subscription DLQ messages go: topic (every matching subscription) or subscription (the originating subscription only)")
+	registerConfirmFlag(cmd)
+	registerDryRunFlag(cmd)
+	cmd.MarkFlagsMutuallyExclusive("all", "queue")
+	cmd.MarkFlagsMutuallyExclusive("all", "topic")
+	cmd.MarkFlagsMutuallyExclusive("all", "subscription")
+
+	return cmd
+}
+
+func newDLQClearCommand() *cobra.Command {
+	var entity entityFlags
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "clear",
+		Short: "Clears (deletes) DLQ messages of a queue or subscription.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if all {
+				return ClearAllDLQMessages(cmd.Context())
+			}
+
+			if err := requireEntityFlags(entity); err != nil {
+				return err
+			}
+
+			entity.apply()
+
+			return ClearDLQMessages(cmd.Context())
+		},
+	}
+
+	entity.register(cmd)
+	cmd.Flags().BoolVar(&all, "all", false, "clear DLQ messages from all subscriptions and queues")
+	registerConfirmFlag(cmd)
+	registerDryRunFlag(cmd)
+	cmd.MarkFlagsMutuallyExclusive("all", "queue")
+	cmd.MarkFlagsMutuallyExclusive("all", "topic")
+	cmd.MarkFlagsMutuallyExclusive("all", "subscription")
+
+	return cmd
+}
+
+func newPublishCommand() *cobra.Command {
+	var queue string
+	var topic string
+	var fileName string
+	var overrides overrideFlags
+
+	cmd := &cobra.Command{
+		Use:   "publish",
+		Short: "Publishes messages from a JSONL file to a queue or topic.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if queue != "" {
+				appContext.SetQueue(queue)
+			}
+
+			if topic != "" {
+				appContext.SetTopic(topic)
+			}
+
+			messageOverrides, err := overrides.parse()
+			if err != nil {
+				return err
+			}
+
+			return PublishMessages(cmd.Context(), fileName, messageOverrides)
+		},
+	}
+
+	cmd.Flags().StringVarP(&queue, "queue", "q", "", "queue to publish to")
+	cmd.Flags().StringVarP(&topic, "topic", "t", "", "topic to publish to")
+	cmd.Flags().StringVarP(&fileName, "file", "f", "", "JSONL file to read messages from")
+	cmd.MarkFlagsMutuallyExclusive("queue", "topic")
+	overrides.register(cmd)
+	registerDryRunFlag(cmd)
+
+	return cmd
+}
+
+func newSelectCommand() *cobra.Command {
+	var entity entityFlags
+
+	cmd := &cobra.Command{
+		Use:   "select",
+		Short: "Selects a queue, or a topic and subscription, and opens the interactive menu.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entity.apply()
+
+			if err := ValidateEntity(cmd.Context()); err != nil {
+				return err
+			}
+
+			return runMenu()
+		},
+	}
+
+	entity.register(cmd)
+
+	return cmd
+}
+
+func newLocalCommand() *cobra.Command {
+	local := &cobra.Command{
+		Use:   "local",
+		Short: "Manage the local namespace used with --local for offline rehearsal.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Without a local namespace the root would set up a connection, prompting for a connection string
+			if appContext.LocalDir == "" {
+				return errors.New("--local must point to the directory of the local namespace")
+			}
+
+			return cmd.Root().PersistentPreRunE(cmd, args)
+		},
+	}
+
+	local.AddCommand(newLocalImportCommand())
+
+	return local
+}
+
+func newLocalImportCommand() *cobra.Command {
+	var entity entityFlags
+	var fileName string
+	var active bool
+
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Imports a JSONL message export into a queue or subscription of the local namespace, creating it if needed.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if entity.queue == "" && (entity.topic == "" || entity.subscription == "") {
+				return errors.New("either --queue, or --topic and --subscription must be specified")
+			}
+
+			entity.apply()
+
+			return ImportLocalMessages(fileName, !active)
+		},
+	}
+
+	entity.register(cmd)
+	cmd.Flags().StringVarP(&fileName, "file", "f", "", "JSONL file exported by dlq download or browse")
+	cmd.Flags().BoolVar(&active, "active", false, "import into the active messages instead of the DLQ")
+	cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+func newAuditCommand() *cobra.Command {
+	audit := &cobra.Command{
+		Use:   "audit",
+		Short: "Work with the audit log of commands that publish, resend or remove messages.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// The audit log is local, there is nothing to connect to
+			return nil
+		},
+	}
+
+	audit.AddCommand(&cobra.Command{
+		Use:   "verify",
+		Short: "Checks that no entry of the audit log has been modified, inserted or removed, and prints the hash of the last entry.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return VerifyAuditLog()
+		},
+	})
+
+	return audit
+}
+
+func registerOutputFlag(cmd *cobra.Command, output *string) {
+	cmd.Flags().StringVarP(output, "output", "o", "table", "output format: "+strings.Join(stats.Formats, ", "))
+}
+
+// registerConfirmFlag lets scripts confirm a destructive command on a protected profile without a prompt.
+func registerConfirmFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&appContext.Confirmation, "confirm", "", "namespace name confirming the command on a protected profile")
+}
+
+// registerDryRunFlag makes a command preview what it would do instead of doing it.
+func registerDryRunFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&appContext.DryRun, "dry-run", false, "show what would be done, gathered without locking or sending anything")
+}
+
+// requireEntityFlags guards destructive commands against falling back to interactive selection or to environment defaults.
+func requireEntityFlags(entity entityFlags) error {
+	if entity.queue == "" && (entity.topic == "" || entity.subscription == "") {
+		return errors.New("either --queue, --topic and --subscription, or --all must be specified")
+	}
+
+	return nil
+}
+
+func parseReceiveMode(mode string) (azservicebus.ReceiveMode, error) {
+	switch strings.ToLower(mode) {
+	case "peeklock", "peek-lock":
+		return azservicebus.ReceiveModePeekLock, nil
+	case "receiveanddelete", "receive-and-delete":
+		return azservicebus.ReceiveModeReceiveAndDelete, nil
+	default:
+		return 0, fmt.Errorf("unknown receive mode %q", mode)
+	}
+}
+
+// parseFilter parses an optional filter expression, returning nil when it is empty.
+func parseFilter(expression string) (*filter.Filter, error) {
+	if expression == "" {
+		return nil, nil
+	}
+
+	return filter.Parse(expression)
+}
@@ -1,35 +1,145 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
-	"log"
+	"net/http"
 	"os"
+	"service-bus-hero/check"
+	"service-bus-hero/config"
+	"service-bus-hero/exporter"
+	"service-bus-hero/filter"
 	"service-bus-hero/io"
 	"service-bus-hero/prompts"
+	"service-bus-hero/stats"
 	"service-bus-hero/topics"
+	"slices"
+	"strings"
 	"sync"
-	"text/tabwriter"
 	"time"
 )
 
-func GetConnectionString() {
-	if appContext.ConnectionString != "" {
-		return
+func GetCredentials() error {
+	if appContext.LocalDir != "" {
+		return appContext.OpenLocal()
 	}
 
-	connStr, err := prompts.PromptConnectionString()
+	appContext.ResolveAuthMethod()
+
+	if appContext.AuthMethod == topics.AuthConnectionString && appContext.ConnectionString == "" {
+		connStr, err := prompts.PromptConnectionString()
+		if err != nil {
+			return fmt.Errorf("failed to get connection string: %w", err)
+		}
+
+		appContext.ConnectionString = connStr
+	}
+
+	if appContext.AuthMethod != topics.AuthConnectionString && appContext.Namespace == "" {
+		namespace, err := prompts.PromptNamespace()
+		if err != nil {
+			return fmt.Errorf("failed to get namespace: %w", err)
+		}
+
+		appContext.Namespace = namespace
+	}
+
+	if err := appContext.BuildCredentials(); err != nil {
+		return fmt.Errorf("failed to create credentials: %w", err)
+	}
+
+	return nil
+}
+
+// customProfile is offered next to the profiles of the config file to connect with a connection string that is not in it.
+const customProfile = "Other connection string..."
+
+// LoadConfig reads the config file and applies the named profile, or SBHERO_PROFILE when no name is given.
+// With useDefault the default profile of the config file is applied when the environment configures no
+// connection either.
+func LoadConfig(name string, useDefault bool) error {
+	path, err := config.DefaultPath()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+
+	appContext.Config = cfg
+
+	if name == "" {
+		name = os.Getenv("SBHERO_PROFILE")
+	}
 
+	if name == "" && useDefault && appContext.ConnectionString == "" && appContext.Namespace == "" {
+		name = cfg.DefaultProfile
+	}
+
+	if name == "" {
+		return nil
+	}
+
+	return UseProfile(name)
+}
+
+// UseProfile applies the named profile to the app context without connecting.
+func UseProfile(name string) error {
+	profile, err := appContext.Config.Profile(name)
 	if err != nil {
-		log.Fatalf("Failed to get connection string: %v", err)
-		os.Exit(1)
+		return err
 	}
 
-	appContext.ConnectionString = connStr
+	return appContext.ApplyProfile(name, profile)
 }
 
-func SelectTopic() error {
-	allTopics, err := topics.FetchTopics(appContext.ConnectionString)
+// SwitchProfile connects to a profile picked from the config file, or to a connection string entered instead.
+func SwitchProfile() error {
+	names := append(appContext.Config.Names(), customProfile)
+
+	name, err := prompts.PromptSelectProfile(names)
+	if err != nil {
+		return fmt.Errorf("could not select profile: %w", err)
+	}
+
+	if name == customProfile {
+		return ChangeConnectionString()
+	}
+
+	if err := UseProfile(name); err != nil {
+		return err
+	}
+
+	// A profile always points at Service Bus, also when the session started on a local namespace
+	appContext.LocalDir = ""
+
+	return GetCredentials()
+}
+
+func ChangeConnectionString() error {
+	connStr, err := prompts.PromptConnectionString()
+	if err != nil {
+		return fmt.Errorf("could not get connection string: %w", err)
+	}
+
+	appContext.SetConnectionString(connStr)
+	appContext.Profile = ""
+	appContext.ProfileReadOnly = false
+	appContext.Protected = false
+
+	if err := appContext.BuildCredentials(); err != nil {
+		return fmt.Errorf("could not create credentials: %w", err)
+	}
+
+	return nil
+}
+
+func SelectTopic(ctx context.Context) error {
+	allTopics, err := appContext.Client.FetchTopics(ctx)
 
 	if err != nil {
 		return fmt.Errorf("could not fetch topics: %w", err)
@@ -40,13 +150,13 @@ func SelectTopic() error {
 		return fmt.Errorf("could not select topic: %w", err)
 	}
 
-	appContext.Topic = selectedTopic
+	appContext.SetTopic(selectedTopic)
 
 	return nil
 }
 
-func SelectSubscription() error {
-	allSubscriptions, err := topics.FetchTopicSubscriptions(appContext.ConnectionString, appContext.Topic)
+func SelectSubscription(ctx context.Context) error {
+	allSubscriptions, err := appContext.Client.FetchTopicSubscriptions(ctx, appContext.Topic)
 	if err != nil {
 		return fmt.Errorf("could not fetch subscriptions: %w", err)
 	}
@@ -56,263 +166,842 @@ func SelectSubscription() error {
 		return fmt.Errorf("could not select subscription: %w", err)
 	}
 
-	appContext.Subscription = selectedSubscription
+	appContext.SetSubscription(selectedSubscription)
 
 	return nil
 }
 
-func ListTopicStatByTopics() error {
-	allTopics, err := topics.FetchTopics(appContext.ConnectionString)
+func SelectQueue(ctx context.Context) error {
+	allQueues, err := appContext.Client.FetchQueues(ctx)
 	if err != nil {
-		return fmt.Errorf("could not fetch topics: %w", err)
+		return fmt.Errorf("could not fetch queues: %w", err)
+	}
+
+	selectedQueue, err := prompts.PromptSelectQueue(allQueues)
+	if err != nil {
+		return fmt.Errorf("could not select queue: %w", err)
+	}
+
+	appContext.SetQueue(selectedQueue)
+
+	return nil
+}
+
+// requireWritable refuses commands that send, resend or remove messages in read-only mode or when the
+// profile is read-only. Rehearsals against a local namespace are always allowed.
+func requireWritable(action string) error {
+	if appContext.Writable() {
+		return nil
+	}
+
+	if appContext.ReadOnly {
+		return fmt.Errorf("cannot %s in read-only mode", action)
 	}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 8, 4, '\t', 0)
-	fmt.Fprintln(w, "Topic\tSubscription\tActive Messages\tDLQ Messages\t")
+	return fmt.Errorf("cannot %s: profile %s is read-only", action, appContext.Profile)
+}
+
+// confirmDestructive checks that action may run and, on a protected profile, that the namespace name was
+// typed, or passed with --confirm, before messages are resent or removed.
+func confirmDestructive(action string) error {
+	if err := requireWritable(action); err != nil {
+		return err
+	}
+
+	if !appContext.Protected || appContext.Local != nil {
+		return nil
+	}
+
+	namespace := appContext.Client.NamespaceName()
+	name, _, _ := strings.Cut(namespace, ".")
+
+	confirmation := appContext.Confirmation
+	if confirmation == "" {
+		fmt.Printf("Profile %s is protected. Type %s to %s, or pass --confirm %s on the command line.\n", appContext.Profile, name, action, name)
 
-	for _, topic := range allTopics {
-		err = WriteTopicSubscriptionsStats(w, topic, false)
+		typed, err := prompts.PromptConfirmation(name)
 		if err != nil {
-			return fmt.Errorf("could not write topic subscription stats: %w", err)
+			return fmt.Errorf("could not confirm: %w", err)
 		}
+
+		confirmation = typed
 	}
 
-	// Ensure all data is flushed to standard output
-	if err := w.Flush(); err != nil {
-		return fmt.Errorf("could not flush writer: %w", err)
+	confirmation = strings.TrimSpace(confirmation)
+	if confirmation != name && confirmation != namespace {
+		return fmt.Errorf("cannot %s: %q does not match namespace %s", action, confirmation, name)
 	}
 
 	return nil
 }
 
-func ListDLQStats() error {
-	allTopics, err := topics.FetchTopics(appContext.ConnectionString)
-	if err != nil {
-		return fmt.Errorf("could not fetch topics: %w", err)
+func requireEntity(ctx context.Context) error {
+	if appContext.EntityKind == topics.EntityKindQueue {
+		if appContext.Queue == "" {
+			err := SelectQueue(ctx)
+			if err != nil {
+				return fmt.Errorf("could not select queue: %w", err)
+			}
+		}
+
+		return nil
 	}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 8, 4, '\t', 0)
-	fmt.Fprintln(w, "Topic\tSubscription\tActive Messages\tDLQ Messages\t")
+	if appContext.Topic == "" {
+		err := SelectTopic(ctx)
+		if err != nil {
+			return fmt.Errorf("could not select topic: %w", err)
+		}
+	}
 
-	for _, topic := range allTopics {
-		err = WriteTopicSubscriptionsStats(w, topic, true)
+	if appContext.Subscription == "" {
+		err := SelectSubscription(ctx)
 		if err != nil {
-			return fmt.Errorf("could not write topic subscription stats: %w", err)
+			return fmt.Errorf("could not select subscription: %w", err)
 		}
 	}
 
-	// Ensure all data is flushed to standard output
-	if err := w.Flush(); err != nil {
-		return fmt.Errorf("could not flush writer: %w", err)
+	return nil
+}
+
+func requireSendTarget(ctx context.Context) error {
+	if appContext.EntityKind == topics.EntityKindQueue {
+		if appContext.Queue == "" {
+			err := SelectQueue(ctx)
+			if err != nil {
+				return fmt.Errorf("could not select queue: %w", err)
+			}
+		}
+
+		return nil
+	}
+
+	if appContext.Topic == "" {
+		err := SelectTopic(ctx)
+		if err != nil {
+			return fmt.Errorf("could not select topic: %w", err)
+		}
 	}
 
 	return nil
 }
 
-func WriteTopicSubscriptionsStats(w *tabwriter.Writer, topic string, dlqOnly bool) error {
-	allSubscriptions, err := topics.FetchTopicSubscriptions(appContext.ConnectionString, topic)
+func ValidateEntity(ctx context.Context) error {
+	if appContext.EntityKind == topics.EntityKindQueue {
+		allQueues, err := appContext.Client.FetchQueues(ctx)
+		if err != nil {
+			return fmt.Errorf("could not fetch queues: %w", err)
+		}
+
+		if !slices.Contains(allQueues, appContext.Queue) {
+			return fmt.Errorf("queue %q does not exist", appContext.Queue)
+		}
+
+		return nil
+	}
+
+	if appContext.Topic == "" {
+		return nil
+	}
+
+	allTopics, err := appContext.Client.FetchTopics(ctx)
+	if err != nil {
+		return fmt.Errorf("could not fetch topics: %w", err)
+	}
+
+	if !slices.Contains(allTopics, appContext.Topic) {
+		return fmt.Errorf("topic %q does not exist", appContext.Topic)
+	}
+
+	if appContext.Subscription == "" {
+		return nil
+	}
+
+	allSubscriptions, err := appContext.Client.FetchTopicSubscriptions(ctx, appContext.Topic)
 	if err != nil {
 		return fmt.Errorf("could not fetch subscriptions: %w", err)
 	}
 
-	for _, subscription := range allSubscriptions {
-		subscriptionStats, err := topics.FetchTopicSubscriptionStats(appContext.ConnectionString, topic, subscription)
+	if !slices.Contains(allSubscriptions, appContext.Subscription) {
+		return fmt.Errorf("subscription %q does not exist on topic %q", appContext.Subscription, appContext.Topic)
+	}
+
+	return nil
+}
+
+func ListTopicStatByTopics(ctx context.Context, format string) error {
+	return writeStats(ctx, format, true, false, false)
+}
+
+func ListDLQStats(ctx context.Context, format string) error {
+	return writeStats(ctx, format, true, true, true)
+}
+
+func ListQueueStats(ctx context.Context, format string) error {
+	return writeStats(ctx, format, false, true, false)
+}
+
+// writeStats collects a snapshot of the topics and subscriptions, the queues, or both, and renders it
+// in the given output format. With dlqOnly only entities holding dead-lettered messages are rendered.
+func writeStats(ctx context.Context, format string, includeTopics bool, includeQueues bool, dlqOnly bool) error {
+	renderer, err := stats.NewRenderer(format)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := collectStats(ctx, includeTopics, includeQueues)
+	if err != nil {
+		return err
+	}
+
+	if dlqOnly {
+		snapshot = snapshot.DeadLettered()
+	}
+
+	return renderer.Render(os.Stdout, snapshot)
+}
+
+func collectStats(ctx context.Context, includeTopics bool, includeQueues bool) (*stats.Snapshot, error) {
+	snapshot := stats.NewSnapshot(appContext.Client.NamespaceName())
+
+	if includeTopics {
+		topicStats, err := appContext.Client.CollectTopicStats(ctx, appContext.Concurrency)
 		if err != nil {
-			return fmt.Errorf("could not fetch subscription stat: %w", err)
+			return nil, err
 		}
 
-		if dlqOnly && subscriptionStats.DeadLetterMessageCount == 0 {
-			continue
+		snapshot.AddTopics(topicStats)
+	}
+
+	if includeQueues {
+		queueStats, err := appContext.Client.CollectQueueStats(ctx)
+		if err != nil {
+			return nil, err
 		}
 
-		// Write each subscription's stats in a row
-		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t\n", topic, subscription, subscriptionStats.ActiveMessageCount, subscriptionStats.DeadLetterMessageCount)
+		snapshot.AddQueues(queueStats)
 	}
 
-	return nil
+	return snapshot, nil
 }
 
-func WriteDLQMessagesToFile(receiveMode azservicebus.ReceiveMode) error {
-	currentTime := time.Now()
-	timestamp := currentTime.Format("20060102-150405")
+// WatchStats collects the namespace stats every interval and redraws them in place until ctx is cancelled.
+func WatchStats(ctx context.Context, interval time.Duration, dlqOnly bool) error {
+	renderer := &stats.DeltaRenderer{DLQOnly: dlqOnly}
 
-	if appContext.Topic == "" {
-		err := SelectTopic()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		snapshot, err := collectStats(ctx, true, true)
+		if err != nil && ctx.Err() != nil {
+			fmt.Println("")
+			return nil
+		}
 		if err != nil {
-			return fmt.Errorf("could not select topic: %w", err)
+			return err
+		}
+
+		// Move the cursor home and clear the screen before drawing the next table
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("%s, every %s, updated %s (Ctrl+C to stop)\n\n", snapshot.Namespace, interval, snapshot.CollectedAt.Local().Format(time.TimeOnly))
+
+		if err := renderer.Render(os.Stdout, snapshot); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			fmt.Println("")
+			return nil
+		case <-ticker.C:
 		}
 	}
+}
 
-	if appContext.Subscription == "" {
-		err := SelectSubscription()
+// ServeMetrics exposes the namespace stats for Prometheus on addr until ctx is cancelled. Stats are
+// collected on scrape and reused for scrapes arriving within maxAge.
+func ServeMetrics(ctx context.Context, addr string, maxAge time.Duration) error {
+	source := exporter.SourceFunc(func(ctx context.Context) (*stats.Snapshot, error) {
+		return collectStats(ctx, true, true)
+	})
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           exporter.New(source, maxAge).Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.ListenAndServe()
+	}()
+
+	fmt.Printf("Serving metrics of %s on %s/metrics\n", appContext.Client.NamespaceName(), addr)
+
+	select {
+	case err := <-errChan:
+		return fmt.Errorf("could not serve metrics: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return server.Shutdown(shutdownCtx)
+}
+
+// RunChecks evaluates threshold rules against the current stats and prints a report. It returns
+// errChecksFailed when any rule is violated, and an error when stats a rule depends on could not be
+// collected, since the rule may be violated by the entities that are missing.
+func RunChecks(ctx context.Context, expressions []string) error {
+	var rules []check.Rule
+	for _, expression := range expressions {
+		rule, err := check.Parse(expression)
 		if err != nil {
-			return fmt.Errorf("could not select subscription: %w", err)
+			return err
 		}
+		rules = append(rules, rule)
+	}
+
+	if len(rules) == 0 {
+		return errors.New("no rules to check")
+	}
+
+	snapshot, err := collectStats(ctx, true, true)
+	if err != nil {
+		return err
+	}
+
+	report := check.Evaluate(rules, snapshot)
+	report.Write(os.Stdout)
+
+	if errored := report.Errored(); errored > 0 {
+		return fmt.Errorf("stats for %d of %d checks could not be collected", errored, len(rules))
+	}
+
+	if report.Failed() > 0 {
+		return errChecksFailed
+	}
+
+	return nil
+}
+
+func WriteDLQMessagesToFile(ctx context.Context, receiveMode azservicebus.ReceiveMode, fileName string, messageFilter *filter.Filter) error {
+	if receiveMode == azservicebus.ReceiveModeReceiveAndDelete {
+		if err := requireWritable("receive and delete DLQ messages"); err != nil {
+			return err
+		}
+	}
+
+	if err := requireEntity(ctx); err != nil {
+		return err
+	}
+
+	entity := appContext.Entity()
+
+	if receiveMode == azservicebus.ReceiveModeReceiveAndDelete {
+		if err := confirmDestructive(fmt.Sprintf("receive and delete the DLQ messages of %s", entity)); err != nil {
+			return err
+		}
+	}
+
+	fileName, err := messagesFileName(fileName, entity, "dlq-messages")
+	if err != nil {
+		return err
+	}
+
+	options := topics.FetchOptions{ReceiveMode: receiveMode}
+	if messageFilter != nil {
+		options.Filter = messageFilter.Match
+	}
+
+	if receiveMode != azservicebus.ReceiveModeReceiveAndDelete {
+		return writeMessagesToFile(ctx, fileName, func(ctx context.Context) (<-chan *azservicebus.ReceivedMessage, <-chan error) {
+			return appContext.Client.FetchDLQMessages(ctx, entity, options)
+		})
+	}
+
+	details := map[string]string{"receiveMode": "receiveanddelete", "file": fileName}
+	if messageFilter != nil {
+		details["filter"] = messageFilter.String()
+	}
+
+	record, err := startAudit("dlq download", entity.String(), details)
+	if err != nil {
+		return err
+	}
+
+	err = writeMessagesToFile(ctx, fileName, func(ctx context.Context) (<-chan *azservicebus.ReceivedMessage, <-chan error) {
+		messageChan, errChan := appContext.Client.FetchDLQMessages(ctx, entity, options)
+		return record.tee(messageChan), errChan
+	})
+
+	// Every message handed over has been removed from the DLQ, whether or not it reached the file
+	return record.finish(len(record.messageIDs), err)
+}
+
+// BrowseMessagesToFile exports the active or dead-lettered messages of the selected entity without
+// locking them.
+func BrowseMessagesToFile(ctx context.Context, deadLetter bool, fileName string, messageFilter *filter.Filter) error {
+	if err := requireEntity(ctx); err != nil {
+		return err
+	}
+
+	entity := appContext.Entity()
+
+	suffix := "messages"
+	if deadLetter {
+		suffix = "dlq-messages"
+	}
+
+	fileName, err := messagesFileName(fileName, entity, suffix)
+	if err != nil {
+		return err
+	}
+
+	options := topics.BrowseOptions{DeadLetter: deadLetter}
+	if messageFilter != nil {
+		options.Filter = messageFilter.Match
+	}
+
+	return writeMessagesToFile(ctx, fileName, func(ctx context.Context) (<-chan *azservicebus.ReceivedMessage, <-chan error) {
+		return appContext.Client.BrowseMessages(ctx, entity, options)
+	})
+}
+
+// messagesFileName prompts for the export file name when none is given, suggesting a timestamped one.
+func messagesFileName(fileName string, entity topics.Entity, suffix string) (string, error) {
+	if fileName != "" {
+		return fileName, nil
 	}
 
-	defaultFileName := fmt.Sprintf("%s-%s-%s-dlq-messages.jsonl", appContext.Topic, appContext.Subscription, timestamp)
+	timestamp := time.Now().Format("20060102-150405")
+	defaultFileName := fmt.Sprintf("%s-%s-%s.jsonl", strings.ReplaceAll(entity.String(), "/", "-"), timestamp, suffix)
 
 	fileName, err := prompts.PromptFileName(&defaultFileName)
 	if err != nil {
-		return fmt.Errorf("could not get file name: %w", err)
+		return "", fmt.Errorf("could not get file name: %w", err)
 	}
 
-	messageChan, errChan := topics.FetchDLQMessages(appContext.ConnectionString, appContext.Topic, appContext.Subscription, receiveMode)
+	return fileName, nil
+}
+
+// writeMessagesToFile writes the messages fetch streams to fileName. When the fetch is interrupted every
+// message received so far is still written, and the interruption is returned after the summary. A fetch
+// that fails is returned as an error naming how many messages reached the file. The file is created before
+// anything is fetched, and when it cannot be written the fetch is stopped and the write error is returned.
+func writeMessagesToFile(ctx context.Context, fileName string, fetch func(ctx context.Context) (<-chan *azservicebus.ReceivedMessage, <-chan error)) error {
+	writer, err := io.CreateMessageWriter(fileName)
+	if err != nil {
+		return fmt.Errorf("could not write messages to %s: %w", fileName, err)
+	}
+
+	fetchCtx, stopFetch := context.WithCancelCause(ctx)
+	defer stopFetch(nil)
+
+	messageChan, errChan := fetch(fetchCtx)
 
 	var wg sync.WaitGroup
 	var totalMessages int
+	var writeErr error
+	var fetchErr error
+	var interruptErr error
 
 	wg.Add(1)
-	go func(totalMessagesPtr *int) {
+	go func() {
 		defer wg.Done()
-		total, err := io.WriteMessagesToJsonLinesFile(messageChan, fileName)
-		if err != nil {
-			fmt.Printf("Error writing messages to file: %v\n", err)
-			return
-		}
-
-		*totalMessagesPtr = total
-	}(&totalMessages)
+		totalMessages, writeErr = io.WriteMessages(writer, messageChan, stopFetch)
+	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		for err := range errChan {
-			if err != nil {
-				fmt.Printf("Error processing messages: %v\n", err)
-				// Handle the error as needed
+			if errors.Is(err, topics.ErrInterrupted) {
+				interruptErr = err
+				continue
+			}
+			if err != nil && fetchErr == nil {
+				fetchErr = err
 			}
 		}
 	}()
 
 	wg.Wait()
 
+	if writeErr != nil {
+		return fmt.Errorf("could not write messages to %s: %w", fileName, writeErr)
+	}
+
+	if fetchErr != nil {
+		return fmt.Errorf("stopped after writing %d messages to %s: %w", totalMessages, fileName, fetchErr)
+	}
+
+	if interruptErr != nil {
+		fmt.Printf("Interrupted, %d messages received so far written to file: %s\n", totalMessages, fileName)
+		return interruptErr
+	}
+
 	fmt.Printf("%d messages written to file: %s\n", totalMessages, fileName)
 
 	return nil
 }
 
-func ResendAllDLQMessages() error {
-	allTopics, err := topics.FetchTopics(appContext.ConnectionString)
+func ResendDLQMessages(ctx context.Context, options *topics.ResendOptions) error {
+	if appContext.DryRun {
+		return previewDLQ(ctx, true, options)
+	}
+
+	if err := requireWritable("resend DLQ messages"); err != nil {
+		return err
+	}
+
+	if err := requireEntity(ctx); err != nil {
+		return err
+	}
+
+	entity := appContext.Entity()
+
+	if err := confirmDestructive(fmt.Sprintf("resend the DLQ messages of %s", entity)); err != nil {
+		return err
+	}
+
+	fmt.Printf("Resending DLQ messages from %s...\n", entity)
+
+	if _, err := resendEntityDLQ(ctx, entity, options); err != nil {
+		return fmt.Errorf("could not resend DLQ messages: %w", err)
+	}
+
+	return nil
+}
+
+func ResendAllDLQMessages(ctx context.Context, options *topics.ResendOptions) error {
+	if appContext.DryRun {
+		return previewAllDLQs(ctx, true, options)
+	}
+
+	if err := requireWritable("resend DLQ messages"); err != nil {
+		return err
+	}
+
+	entities, err := fetchDLQEntities(ctx)
 	if err != nil {
-		return fmt.Errorf("could not fetch topics: %w", err)
+		return err
 	}
 
-	totalResent := 0
+	if len(entities) > 0 {
+		if err := confirmDestructive(fmt.Sprintf("resend the DLQ messages of %d subscriptions and queues", len(entities))); err != nil {
+			return err
+		}
+	}
+
+	var total topics.ResendResult
+
+	for _, dlq := range entities {
+		fmt.Printf("Resending %d DLQ messages from %s...\n", dlq.count, dlq.entity)
+
+		result, err := resendEntityDLQ(ctx, dlq.entity, options)
+		total.Sent += result.Sent
+		total.Completed += result.Completed
+		total.Abandoned += result.Abandoned
+		total.Skipped += result.Skipped
 
-	for _, topic := range allTopics {
-		subscriptions, err := topics.FetchTopicSubscriptions(appContext.ConnectionString, topic)
+		if errors.Is(err, topics.ErrInterrupted) {
+			fmt.Printf("\nInterrupted, total so far: %s\n", total)
+			return err
+		}
 		if err != nil {
-			fmt.Printf("Error fetching subscriptions for topic %s: %v\n", topic, err)
-			continue
+			fmt.Printf("Error resending DLQ messages for %s: %v\n", dlq.entity, err)
 		}
+	}
 
-		for _, subscription := range subscriptions {
-			stats, err := topics.FetchTopicSubscriptionStats(appContext.ConnectionString, topic, subscription)
-			if err != nil {
-				fmt.Printf("Error fetching stats for %s/%s: %v\n", topic, subscription, err)
-				continue
-			}
+	fmt.Printf("\nTotal: %s\n", total)
+	return nil
+}
 
-			if stats.DeadLetterMessageCount == 0 {
-				continue
-			}
+// resendEntityDLQ resends the DLQ messages of entity, archiving and auditing them on the way.
+func resendEntityDLQ(ctx context.Context, entity topics.Entity, options *topics.ResendOptions) (topics.ResendResult, error) {
+	archive := newDLQArchive(entity, "resend")
 
-			fmt.Printf("Resending %d DLQ messages from %s/%s...\n", stats.DeadLetterMessageCount, topic, subscription)
+	details := map[string]string{"archive": archive.fileName}
+	if options != nil {
+		if entity.Kind == topics.EntityKindSubscription {
+			details["redelivery"] = options.Redelivery.String()
+		}
+		details["overrides"] = options.Overrides.String()
+	}
 
-			count, err := topics.ResendDLQMessages(appContext.ConnectionString, topic, subscription)
-			if err != nil {
-				fmt.Printf("Error resending DLQ messages for %s/%s: %v\n", topic, subscription, err)
-				continue
-			}
+	record, err := startAudit("dlq resend", entity.String(), details)
+	if err != nil {
+		return topics.ResendResult{}, err
+	}
 
-			totalResent += count
-			fmt.Printf("Resent %d messages from %s/%s\n", count, topic, subscription)
-		}
+	resendOptions := archivedResendOptions(options, archive)
+	resendOptions.Resent = record.addMessages
+
+	result, err := appContext.Client.ResendDLQMessages(ctx, entity, resendOptions)
+	archive.close()
+	fmt.Printf("Resend from %s: %s\n", entity, result)
+
+	record.details["result"] = result.String()
+
+	return result, record.finish(result.Sent, err)
+}
+
+// PromptFilter asks for an optional filter expression, returning nil when none is entered.
+func PromptFilter() (*filter.Filter, error) {
+	expression, err := prompts.PromptFilterExpression()
+	if err != nil {
+		return nil, fmt.Errorf("could not get filter: %w", err)
+	}
+
+	if strings.TrimSpace(expression) == "" {
+		return nil, nil
 	}
 
-	fmt.Printf("\nTotal messages resent: %d\n", totalResent)
+	return filter.Parse(expression)
+}
+
+// PromptResendOptions asks whether DLQ messages of subscriptions go back to the whole topic
+// or to the originating subscription only.
+func PromptResendOptions() (*topics.ResendOptions, error) {
+	mode, err := prompts.PromptSelectRedeliveryMode()
+	if err != nil {
+		return nil, fmt.Errorf("could not select redelivery mode: %w", err)
+	}
+
+	redelivery, err := topics.ParseRedeliveryMode(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &topics.ResendOptions{Redelivery: redelivery}, nil
+}
+
+func ClearDLQMessages(ctx context.Context) error {
+	if appContext.DryRun {
+		return previewDLQ(ctx, false, nil)
+	}
+
+	if err := requireWritable("clear DLQ messages"); err != nil {
+		return err
+	}
+
+	if err := requireEntity(ctx); err != nil {
+		return err
+	}
+
+	entity := appContext.Entity()
+
+	if err := confirmDestructive(fmt.Sprintf("clear the DLQ messages of %s", entity)); err != nil {
+		return err
+	}
+
+	fmt.Printf("Clearing DLQ messages from %s...\n", entity)
+
+	count, err := clearEntityDLQ(ctx, entity)
+	if errors.Is(err, topics.ErrInterrupted) {
+		fmt.Printf("Interrupted, cleared %d messages from %s\n", count, entity)
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("could not clear DLQ messages: %w", err)
+	}
+
+	fmt.Printf("Cleared %d messages from %s\n", count, entity)
 	return nil
 }
 
-func ClearAllDLQMessages() error {
-	allTopics, err := topics.FetchTopics(appContext.ConnectionString)
+func ClearAllDLQMessages(ctx context.Context) error {
+	if appContext.DryRun {
+		return previewAllDLQs(ctx, false, nil)
+	}
+
+	if err := requireWritable("clear DLQ messages"); err != nil {
+		return err
+	}
+
+	entities, err := fetchDLQEntities(ctx)
 	if err != nil {
-		return fmt.Errorf("could not fetch topics: %w", err)
+		return err
+	}
+
+	if len(entities) > 0 {
+		if err := confirmDestructive(fmt.Sprintf("clear the DLQ messages of %d subscriptions and queues", len(entities))); err != nil {
+			return err
+		}
 	}
 
 	totalCleared := 0
 
-	for _, topic := range allTopics {
-		subscriptions, err := topics.FetchTopicSubscriptions(appContext.ConnectionString, topic)
+	for _, dlq := range entities {
+		fmt.Printf("Clearing %d DLQ messages from %s...\n", dlq.count, dlq.entity)
+
+		count, err := clearEntityDLQ(ctx, dlq.entity)
+		if errors.Is(err, topics.ErrInterrupted) {
+			totalCleared += count
+			fmt.Printf("Interrupted, cleared %d messages from %s\n", count, dlq.entity)
+			fmt.Printf("\nTotal messages cleared so far: %d\n", totalCleared)
+			return err
+		}
 		if err != nil {
-			fmt.Printf("Error fetching subscriptions for topic %s: %v\n", topic, err)
+			fmt.Printf("Error clearing DLQ messages for %s: %v\n", dlq.entity, err)
 			continue
 		}
 
-		for _, subscription := range subscriptions {
-			stats, err := topics.FetchTopicSubscriptionStats(appContext.ConnectionString, topic, subscription)
-			if err != nil {
-				fmt.Printf("Error fetching stats for %s/%s: %v\n", topic, subscription, err)
-				continue
-			}
+		totalCleared += count
+		fmt.Printf("Cleared %d messages from %s\n", count, dlq.entity)
+	}
 
-			if stats.DeadLetterMessageCount == 0 {
-				continue
-			}
+	fmt.Printf("\nTotal messages cleared: %d\n", totalCleared)
+	return nil
+}
 
-			fmt.Printf("Clearing %d DLQ messages from %s/%s...\n", stats.DeadLetterMessageCount, topic, subscription)
+// clearEntityDLQ clears the DLQ of entity, archiving and auditing the messages on the way.
+func clearEntityDLQ(ctx context.Context, entity topics.Entity) (int, error) {
+	archive := newDLQArchive(entity, "clear")
 
-			count, err := topics.ClearDLQMessages(appContext.ConnectionString, topic, subscription)
-			if err != nil {
-				fmt.Printf("Error clearing DLQ messages for %s/%s: %v\n", topic, subscription, err)
-				continue
-			}
+	record, err := startAudit("dlq clear", entity.String(), map[string]string{"archive": archive.fileName})
+	if err != nil {
+		return 0, err
+	}
 
-			totalCleared += count
-			fmt.Printf("Cleared %d messages from %s/%s\n", count, topic, subscription)
+	count, err := appContext.Client.ClearDLQMessages(ctx, entity, topics.ClearOptions{Archive: archive.write, Cleared: record.addMessages})
+	archive.close()
+
+	return count, record.finish(count, err)
+}
+
+type dlqEntity struct {
+	entity topics.Entity
+	count  int32
+}
+
+// fetchDLQEntities lists every subscription and queue with dead-lettered messages. Topics whose
+// subscriptions cannot be listed are reported and left out.
+func fetchDLQEntities(ctx context.Context) ([]dlqEntity, error) {
+	topicStats, err := appContext.Client.CollectTopicStats(ctx, appContext.Concurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	queueStats, err := appContext.Client.CollectQueueStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []topics.EntityStats
+
+	for _, topic := range topicStats {
+		if topic.Err != nil {
+			fmt.Printf("Error fetching subscriptions for topic %s: %v\n", topic.Topic, topic.Err)
+			continue
 		}
+
+		rows = append(rows, topic.Subscriptions...)
 	}
 
-	fmt.Printf("\nTotal messages cleared: %d\n", totalCleared)
-	return nil
+	rows = append(rows, queueStats...)
+
+	var entities []dlqEntity
+
+	for _, row := range rows {
+		if row.DeadLetterMessageCount == 0 {
+			continue
+		}
+
+		entities = append(entities, dlqEntity{row.Entity, row.DeadLetterMessageCount})
+	}
+
+	return entities, nil
 }
 
-func PublishMessages() error {
+func PublishMessages(ctx context.Context, fileName string, overrides *io.MessageOverrides) error {
 	var err error
 	var wg sync.WaitGroup
 
-	if appContext.Topic == "" {
-		err = SelectTopic()
+	// A dry run sends nothing, so it is allowed in read-only mode
+	if !appContext.DryRun {
+		if err := requireWritable("publish messages"); err != nil {
+			return err
+		}
+	}
+
+	if err := requireSendTarget(ctx); err != nil {
+		return err
+	}
+
+	if fileName == "" {
+		existingFiles, err := io.ListJsonlFiles()
 		if err != nil {
-			return fmt.Errorf("could not select topic: %w", err)
+			return fmt.Errorf("could not list existing files: %w", err)
+		}
+
+		if len(existingFiles) == 0 {
+			fileName, err = prompts.EnterCustomFileName()
+		} else {
+			fileName, err = prompts.SelectFileOrCustom(existingFiles)
+		}
+		if err != nil {
+			return fmt.Errorf("could not get file name: %w", err)
 		}
 	}
 
-	existingFiles, err := io.ListJsonlFiles()
-	if err != nil {
-		return fmt.Errorf("could not list existing files: %w", err)
+	if appContext.DryRun {
+		return previewPublish(fileName, appContext.Entity().SendTarget())
 	}
 
-	fileName := ""
+	target := appContext.Entity().SendTarget()
 
-	if len(existingFiles) == 0 {
-		fileName, err = prompts.EnterCustomFileName()
-	} else {
-		fileName, err = prompts.SelectFileOrCustom(existingFiles)
+	// Every line is checked first, so an invalid file publishes nothing rather than part of it
+	_, lineErrors, err := io.ValidateJsonLinesFile(fileName)
+	if err != nil {
+		return fmt.Errorf("could not validate %s: %w", fileName, err)
+	}
+	if len(lineErrors) > 0 {
+		for _, lineErr := range lineErrors {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", fileName, lineErr)
+		}
+		return fmt.Errorf("%d lines of %s are invalid, nothing was published", len(lineErrors), fileName)
+	}
+
+	record, err := startAudit("publish", target, map[string]string{"file": fileName, "overrides": overrides.String()})
+	if err != nil {
+		return err
 	}
 
 	messagesChan, errChan := io.ReadMessagesFromJsonLinesFile(fileName)
 	azMessagesChan := make(chan *azservicebus.Message)
 
+	// Lines that cannot be read although the file was valid fail the publish once it is over
+	var readErr error
+	skipped := 0
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		defer close(azMessagesChan)
 
 		for msg := range messagesChan {
-			azMsg := io.TransformMessage(msg)
-			azMessagesChan <- azMsg
+			// The rest of the file is drained without sending once interrupted
+			if ctx.Err() != nil {
+				continue
+			}
+
+			azMsg, err := io.TransformMessage(msg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping message: %v\n", err)
+				skipped++
+				continue
+			}
+
+			overrides.Apply(azMsg)
+
+			select {
+			case azMessagesChan <- azMsg:
+			case <-ctx.Done():
+			}
 		}
 	}()
 
@@ -320,16 +1009,52 @@ func PublishMessages() error {
 	go func() {
 		defer wg.Done()
 		for err := range errChan {
-			// Handle or log the error. Break if necessary.
-			fmt.Println("Error from GenerateDataA:", err)
-			// Optionally, set an external error or handle it as needed.
-			// This example just prints the error.
+			readErr = err
 		}
 	}()
 
-	err = topics.PublishMessagesToTopic(appContext.ConnectionString, appContext.Topic, azMessagesChan)
+	sent, err := appContext.Client.PublishMessages(ctx, target, azMessagesChan, record.addSentMessages)
+
+	// Unblock the reader when publishing stopped before the end of the file
+	for range azMessagesChan {
+	}
 
 	wg.Wait()
 
-	return err
+	if errors.Is(err, topics.ErrInterrupted) {
+		fmt.Printf("Interrupted after publishing %d messages from %s to %s, the rest of the file was not sent\n", sent, fileName, target)
+	}
+
+	if err == nil && readErr != nil {
+		err = fmt.Errorf("could not read %s after publishing %d messages: %w", fileName, sent, readErr)
+	}
+	if err == nil && skipped > 0 {
+		err = fmt.Errorf("%d messages of %s could not be published", skipped, fileName)
+	}
+
+	return record.finish(sent, err)
+}
+
+// ImportLocalMessages copies an exported JSONL file into the selected queue or subscription of the local
+// namespace, so a resend or clear can be rehearsed against it.
+func ImportLocalMessages(fileName string, deadLetter bool) error {
+	entity := appContext.Entity()
+
+	if err := appContext.Local.EnsureEntity(entity); err != nil {
+		return fmt.Errorf("could not create %s: %w", entity, err)
+	}
+
+	count, err := appContext.Local.Import(entity, deadLetter, fileName)
+	if err != nil {
+		return fmt.Errorf("could not import messages: %w", err)
+	}
+
+	target := "active messages"
+	if deadLetter {
+		target = "DLQ"
+	}
+
+	fmt.Printf("Imported %d messages into the %s of %s in %s\n", count, target, entity, appContext.Local.Dir())
+
+	return nil
 }
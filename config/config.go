@@ -0,0 +1,120 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"service-bus-hero/topics"
+	"slices"
+)
+
+// PathEnv overrides where the config file is read from.
+const PathEnv = "SBHERO_CONFIG"
+
+// Profile holds everything needed to work with one namespace: how to authenticate against it, the entity
+// selected when the profile is picked and its safety flags.
+type Profile struct {
+	ConnectionString string `json:"connectionString,omitempty"`
+	Auth             string `json:"auth,omitempty"`
+	Namespace        string `json:"namespace,omitempty"`
+	TenantID         string `json:"tenantId,omitempty"`
+	ClientID         string `json:"clientId,omitempty"`
+	ClientSecret     string `json:"clientSecret,omitempty"`
+
+	Topic        string `json:"topic,omitempty"`
+	Subscription string `json:"subscription,omitempty"`
+	Queue        string `json:"queue,omitempty"`
+
+	// ReadOnly refuses every command that sends, resends or removes messages.
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// Protected asks for the namespace name to be typed before messages are resent or removed.
+	Protected bool `json:"protected,omitempty"`
+}
+
+// AuthMethod returns the configured auth method, or an empty one when it is picked from the other settings.
+func (p Profile) AuthMethod() (topics.AuthMethod, error) {
+	if p.Auth == "" {
+		return "", nil
+	}
+	return topics.ParseAuthMethod(p.Auth)
+}
+
+// Config is the user config file with the named connection profiles.
+type Config struct {
+	// DefaultProfile is used when no profile is picked and no connection is configured in the environment.
+	DefaultProfile string             `json:"defaultProfile,omitempty"`
+	Profiles       map[string]Profile `json:"profiles"`
+
+	path string
+}
+
+// DefaultPath returns the path of the config file: SBHERO_CONFIG when set, and sbhero/config.json in the
+// user config directory otherwise.
+func DefaultPath() (string, error) {
+	if path := os.Getenv(PathEnv); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find user config directory: %w", err)
+	}
+
+	return filepath.Join(dir, "sbhero", "config.json"), nil
+}
+
+// Load reads the config file at path. A missing file is an empty config.
+func Load(path string) (*Config, error) {
+	config := &Config{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+
+	for name, profile := range config.Profiles {
+		if _, err := profile.AuthMethod(); err != nil {
+			return nil, fmt.Errorf("invalid profile %s in %s: %w", name, path, err)
+		}
+	}
+
+	if config.DefaultProfile != "" {
+		if _, ok := config.Profiles[config.DefaultProfile]; !ok {
+			return nil, fmt.Errorf("default profile %s is not defined in %s", config.DefaultProfile, path)
+		}
+	}
+
+	return config, nil
+}
+
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Names returns the profile names in alphabetical order.
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (c *Config) Profile(name string) (Profile, error) {
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("profile %s is not defined in %s", name, c.path)
+	}
+	return profile, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"service-bus-hero/io"
+	"service-bus-hero/topics"
+	"slices"
+	"strings"
+)
+
+// dryRunSampleSize is how many messages of each DLQ a dry run lists.
+const dryRunSampleSize = 5
+
+// previewDLQ prints what resending (when resend is set) or clearing the DLQ of the selected entity would do.
+func previewDLQ(ctx context.Context, resend bool, options *topics.ResendOptions) error {
+	if err := requireEntity(ctx); err != nil {
+		return err
+	}
+
+	printDryRun(resend)
+
+	_, err := previewEntityDLQ(ctx, appContext.Entity(), resend, options)
+
+	return err
+}
+
+// previewAllDLQs prints what resending or clearing the DLQs of every subscription and queue would do.
+func previewAllDLQs(ctx context.Context, resend bool, options *topics.ResendOptions) error {
+	entities, err := fetchDLQEntities(ctx)
+	if err != nil {
+		return err
+	}
+
+	printDryRun(resend)
+
+	total := 0
+
+	for _, dlq := range entities {
+		preview, err := previewEntityDLQ(ctx, dlq.entity, resend, options)
+		if errors.Is(err, topics.ErrInterrupted) {
+			return err
+		}
+		if err != nil {
+			fmt.Printf("Error previewing DLQ messages for %s: %v\n", dlq.entity, err)
+			continue
+		}
+
+		total += preview.Messages - preview.Skipped
+	}
+
+	fmt.Printf("\nTotal: %d messages from %d subscriptions and queues would be %s\n", total, len(entities), dryRunVerb(resend))
+	return nil
+}
+
+func previewEntityDLQ(ctx context.Context, entity topics.Entity, resend bool, options *topics.ResendOptions) (*topics.DLQPreview, error) {
+	var preview *topics.DLQPreview
+	var err error
+
+	if resend {
+		preview, err = appContext.Client.PreviewResend(ctx, entity, options, dryRunSampleSize)
+	} else {
+		preview, err = appContext.Client.PreviewClear(ctx, entity, dryRunSampleSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	printPreview(preview, resend)
+
+	return preview, nil
+}
+
+func printDryRun(resend bool) {
+	fmt.Printf("Dry run, no messages are %s\n", dryRunVerb(resend))
+}
+
+func dryRunVerb(resend bool) string {
+	if resend {
+		return "resent"
+	}
+	return "cleared"
+}
+
+func printPreview(preview *topics.DLQPreview, resend bool) {
+	fmt.Println("")
+
+	if resend {
+		fmt.Printf("%s: %d messages would be resent to %s\n", preview.Entity, preview.Messages-preview.Skipped, preview.Target)
+	} else {
+		fmt.Printf("%s: %d messages would be cleared\n", preview.Entity, preview.Messages)
+	}
+
+	for _, note := range preview.Notes {
+		fmt.Printf("  %s\n", note)
+	}
+
+	if preview.Skipped > 0 {
+		fmt.Printf("  %d messages would be skipped and stay in the DLQ, a sibling subscription would also receive them\n", preview.Skipped)
+	}
+
+	if len(preview.Reasons) > 0 {
+		fmt.Printf("  Reasons: %s\n", formatReasons(preview.Reasons))
+	}
+
+	if len(preview.Sample) > 0 {
+		fmt.Println("  Sample:")
+	}
+
+	for _, msg := range preview.Sample {
+		fmt.Printf("    %s  %s", msg.MessageID, valueOrNone(msg.DeadLetterReason))
+		if msg.DeadLetterErrorDescription != nil && *msg.DeadLetterErrorDescription != "" {
+			fmt.Printf(": %s", *msg.DeadLetterErrorDescription)
+		}
+		fmt.Println("")
+	}
+}
+
+// formatReasons lists the dead-letter reasons, most frequent first.
+func formatReasons(reasons map[string]int) string {
+	names := make([]string, 0, len(reasons))
+	for reason := range reasons {
+		names = append(names, reason)
+	}
+
+	slices.SortFunc(names, func(a, b string) int {
+		if reasons[a] != reasons[b] {
+			return reasons[b] - reasons[a]
+		}
+		return strings.Compare(a, b)
+	})
+
+	parts := make([]string, len(names))
+	for i, reason := range names {
+		if reason == "" {
+			reason = "(none)"
+		}
+		parts[i] = fmt.Sprintf("%s (%d)", reason, reasons[names[i]])
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func valueOrNone(value *string) string {
+	if value == nil || *value == "" {
+		return "(none)"
+	}
+	return *value
+}
+
+// previewPublish validates every line of fileName and prints how the messages would be published to target.
+func previewPublish(fileName string, target string) error {
+	valid, lineErrors, err := io.ValidateJsonLinesFile(fileName)
+	if err != nil {
+		return fmt.Errorf("could not validate %s: %w", fileName, err)
+	}
+
+	fmt.Println("Dry run, no messages are published")
+
+	for _, lineErr := range lineErrors {
+		fmt.Printf("%s: %v\n", fileName, lineErr)
+	}
+
+	batches := (valid + topics.PublishBatchSize - 1) / topics.PublishBatchSize
+	fmt.Printf("%d messages from %s would be published to %s in %d batches of up to %d\n", valid, fileName, target, batches, topics.PublishBatchSize)
+
+	if len(lineErrors) > 0 {
+		return fmt.Errorf("%d lines of %s are invalid", len(lineErrors), fileName)
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"service-bus-hero/stats"
+	"sync"
+	"time"
+)
+
+// Source produces the snapshots served on /metrics. It is an interface so the exporter can be run
+// against something other than a live namespace.
+type Source interface {
+	Snapshot(ctx context.Context) (*stats.Snapshot, error)
+}
+
+// SourceFunc adapts a function to a Source.
+type SourceFunc func(ctx context.Context) (*stats.Snapshot, error)
+
+func (f SourceFunc) Snapshot(ctx context.Context) (*stats.Snapshot, error) {
+	return f(ctx)
+}
+
+// Exporter serves the stats of a Source in the Prometheus text exposition format. Scrapes arriving
+// within MaxAge of the last collection are answered from the cached snapshot, and concurrent scrapes
+// share a single collection, so the namespace is not queried more often than needed.
+type Exporter struct {
+	source Source
+	maxAge time.Duration
+
+	mu          sync.Mutex
+	snapshot    *stats.Snapshot
+	err         error
+	collectedAt time.Time
+	duration    time.Duration
+}
+
+func New(source Source, maxAge time.Duration) *Exporter {
+	return &Exporter{source: source, maxAge: maxAge}
+}
+
+// Handler serves the metrics on /metrics and a short index page on /.
+func (e *Exporter) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, `<html><body><h1>Service Bus Hero exporter</h1><a href="/metrics">Metrics</a></body></html>`)
+	})
+	return mux
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	snapshot, duration, err := e.collect(r.Context())
+
+	var body bytes.Buffer
+
+	if snapshot != nil {
+		if err := (stats.PrometheusRenderer{}).Render(&body, snapshot); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	up := 1
+	if err != nil {
+		up = 0
+	}
+
+	fmt.Fprintln(&body, "# HELP sbhero_up Whether the last collection of the namespace stats succeeded.")
+	fmt.Fprintln(&body, "# TYPE sbhero_up gauge")
+	fmt.Fprintf(&body, "sbhero_up %d\n", up)
+	fmt.Fprintln(&body, "# HELP sbhero_collect_duration_seconds How long the last collection of the namespace stats took.")
+	fmt.Fprintln(&body, "# TYPE sbhero_collect_duration_seconds gauge")
+	fmt.Fprintf(&body, "sbhero_collect_duration_seconds %g\n", duration.Seconds())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(body.Bytes())
+}
+
+// collect returns the cached snapshot while it is fresh and collects a new one otherwise. A failed
+// collection is not cached, so the next scrape tries again.
+func (e *Exporter) collect(ctx context.Context) (*stats.Snapshot, time.Duration, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.err == nil && e.snapshot != nil && time.Since(e.collectedAt) < e.maxAge {
+		return e.snapshot, e.duration, nil
+	}
+
+	start := time.Now()
+	snapshot, err := e.source.Snapshot(ctx)
+	e.duration = time.Since(start)
+	e.err = err
+
+	if err != nil {
+		fmt.Printf("Could not collect stats: %v\n", err)
+		return nil, e.duration, err
+	}
+
+	e.snapshot = snapshot
+	e.collectedAt = start
+
+	return snapshot, e.duration, nil
+}
@@ -0,0 +1,211 @@
+package exporter_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"service-bus-hero/exporter"
+	"service-bus-hero/stats"
+	"service-bus-hero/topics"
+	"service-bus-hero/topics/memory"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	ns := newNamespace(t)
+	server := httptest.NewServer(exporter.New(newSource(ns, nil), time.Minute).Handler())
+	defer server.Close()
+
+	body := scrape(t, server)
+
+	labels := fmt.Sprintf(`namespace="%s"`, ns.Name())
+	tests := []string{
+		"# TYPE sbhero_topic_scheduled_messages gauge",
+		fmt.Sprintf(`sbhero_topic_size_bytes{%s,topic="orders"} 3`, labels),
+		fmt.Sprintf(`sbhero_subscription_active_messages{%s,topic="orders",subscription="billing"} 1`, labels),
+		fmt.Sprintf(`sbhero_subscription_dead_letter_messages{%s,topic="orders",subscription="billing"} 2`, labels),
+		fmt.Sprintf(`sbhero_subscription_transfer_dead_letter_messages{%s,topic="orders",subscription="billing"} 0`, labels),
+		fmt.Sprintf(`sbhero_queue_active_messages{%s,queue="invoices"} 2`, labels),
+		fmt.Sprintf(`sbhero_queue_dead_letter_messages{%s,queue="invoices"} 0`, labels),
+		fmt.Sprintf(`sbhero_queue_scheduled_messages{%s,queue="invoices"} 0`, labels),
+		fmt.Sprintf(`sbhero_queue_size_bytes{%s,queue="invoices"} 2`, labels),
+		"sbhero_up 1",
+		"# TYPE sbhero_collect_duration_seconds gauge",
+	}
+
+	for _, want := range tests {
+		t.Run(want, func(t *testing.T) {
+			if !containsLine(body, want) {
+				t.Errorf("/metrics does not contain %q:\n%s", want, body)
+			}
+		})
+	}
+}
+
+func TestMetricsCache(t *testing.T) {
+	invoices := func(count int) string {
+		return fmt.Sprintf(`sbhero_queue_active_messages{namespace="%s",queue="invoices"} %d`, memory.New("test").Name(), count)
+	}
+
+	tests := []struct {
+		name            string
+		maxAge          time.Duration
+		failFirst       bool
+		wantCollections int
+		wantFirst       []string
+		wantSecond      []string
+	}{
+		{
+			name:            "fresh snapshot is reused",
+			maxAge:          time.Minute,
+			wantCollections: 1,
+			wantFirst:       []string{"sbhero_up 1", invoices(2)},
+			wantSecond:      []string{"sbhero_up 1", invoices(2)},
+		},
+		{
+			name:            "stale snapshot is collected again",
+			maxAge:          0,
+			wantCollections: 2,
+			wantFirst:       []string{"sbhero_up 1", invoices(2)},
+			wantSecond:      []string{"sbhero_up 1", invoices(3)},
+		},
+		{
+			name:            "failed collection is not cached",
+			maxAge:          time.Minute,
+			failFirst:       true,
+			wantCollections: 2,
+			wantFirst:       []string{"sbhero_up 0"},
+			wantSecond:      []string{"sbhero_up 1", invoices(3)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := newNamespace(t)
+
+			collections := 0
+			source := newSource(ns, func() error {
+				collections++
+				if test.failFirst && collections == 1 {
+					return errors.New("namespace unavailable")
+				}
+				return nil
+			})
+
+			server := httptest.NewServer(exporter.New(source, test.maxAge).Handler())
+			defer server.Close()
+
+			assertLines(t, scrape(t, server), test.wantFirst)
+
+			// Only a new collection sees the message sent between the scrapes
+			must(t, ns.Send("invoices", message("c")))
+
+			assertLines(t, scrape(t, server), test.wantSecond)
+
+			if collections != test.wantCollections {
+				t.Errorf("collected %d times, want %d", collections, test.wantCollections)
+			}
+		})
+	}
+}
+
+// newNamespace returns a namespace with two active messages in the queue invoices and, in the
+// subscription orders/billing, one active and two dead-lettered messages.
+func newNamespace(t *testing.T) *memory.Namespace {
+	t.Helper()
+
+	ns := memory.New("test")
+	must(t, ns.CreateQueue("invoices"))
+	must(t, ns.CreateTopic("orders"))
+	must(t, ns.CreateSubscription("orders", "billing"))
+
+	billing := topics.NewSubscriptionEntity("orders", "billing")
+	must(t, ns.Send("invoices", message("a"), message("b")))
+	must(t, ns.Send("orders", message("c")))
+	must(t, ns.AddDeadLetter(billing, message("d"), "reason", "description"))
+	must(t, ns.AddDeadLetter(billing, message("e"), "reason", "description"))
+	return ns
+}
+
+// newSource collects the stats of ns the way the metrics command does. before, when given, is called
+// first and fails the collection when it returns an error.
+func newSource(ns *memory.Namespace, before func() error) exporter.Source {
+	client := topics.NewClientWithBackend(ns.Name(), ns, ns)
+
+	return exporter.SourceFunc(func(ctx context.Context) (*stats.Snapshot, error) {
+		if before != nil {
+			if err := before(); err != nil {
+				return nil, err
+			}
+		}
+
+		topicStats, err := client.CollectTopicStats(ctx, 1)
+		if err != nil {
+			return nil, err
+		}
+		queueStats, err := client.CollectQueueStats(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshot := stats.NewSnapshot(client.NamespaceName())
+		snapshot.AddTopics(topicStats)
+		snapshot.AddQueues(queueStats)
+		return snapshot, nil
+	})
+}
+
+func scrape(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	must(t, err)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics = %s, want 200 OK", resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %s, want the Prometheus text format", contentType)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	must(t, err)
+	return string(body)
+}
+
+func assertLines(t *testing.T, body string, want []string) {
+	t.Helper()
+
+	for _, line := range want {
+		if !containsLine(body, line) {
+			t.Errorf("/metrics does not contain %q:\n%s", line, body)
+		}
+	}
+}
+
+func containsLine(body string, line string) bool {
+	for _, l := range strings.Split(body, "\n") {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+func message(id string) *azservicebus.Message {
+	return &azservicebus.Message{MessageID: &id, Body: []byte(id)}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
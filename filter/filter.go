@@ -0,0 +1,229 @@
+package filter
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"strings"
+	"time"
+)
+
+// applicationPropertyPrefix addresses an application property instead of a system property.
+const applicationPropertyPrefix = "app."
+
+// operators are tried in order, so two-character operators have to come before their one-character prefixes.
+var operators = []string{"!=", "^=", "~=", ">=", "<=", "=", ">", "<"}
+
+// Filter is a parsed filter expression: a comma-separated list of conditions that all have to match,
+// for example `reason=MaxDeliveryCountExceeded, enqueued>=-2h, subject^=Order, app.tenant=acme`.
+type Filter struct {
+	expression string
+	conditions []condition
+}
+
+type condition struct {
+	field    string
+	operator string
+	value    string
+	time     time.Time
+}
+
+func Parse(expression string) (*Filter, error) {
+	f := &Filter{expression: strings.TrimSpace(expression)}
+
+	for _, clause := range splitClauses(expression) {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			continue
+		}
+
+		c, err := parseCondition(clause)
+		if err != nil {
+			return nil, err
+		}
+
+		f.conditions = append(f.conditions, c)
+	}
+
+	if len(f.conditions) == 0 {
+		return nil, fmt.Errorf("filter %q has no conditions", expression)
+	}
+
+	return f, nil
+}
+
+func (f *Filter) String() string {
+	return f.expression
+}
+
+func (f *Filter) Match(msg *azservicebus.ReceivedMessage) bool {
+	for _, c := range f.conditions {
+		if !c.match(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitClauses splits on commas outside of double quotes.
+func splitClauses(expression string) []string {
+	var clauses []string
+	var current strings.Builder
+	quoted := false
+
+	for _, r := range expression {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case r == ',' && !quoted:
+			clauses = append(clauses, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	return append(clauses, current.String())
+}
+
+func parseCondition(clause string) (condition, error) {
+	index := -1
+	operator := ""
+
+	for _, op := range operators {
+		i := strings.Index(clause, op)
+		if i >= 0 && (index == -1 || i < index) {
+			index = i
+			operator = op
+		}
+	}
+
+	if index <= 0 {
+		return condition{}, fmt.Errorf("invalid condition %q, expected <field><operator><value>", clause)
+	}
+
+	c := condition{
+		field:    strings.TrimSpace(clause[:index]),
+		operator: operator,
+		value:    strings.Trim(strings.TrimSpace(clause[index+len(operator):]), `"`),
+	}
+
+	if strings.EqualFold(c.field, "enqueued") {
+		t, err := parseTime(c.value)
+		if err != nil {
+			return condition{}, fmt.Errorf("invalid time in %q: %w", clause, err)
+		}
+		c.time = t
+
+		if operator == "^=" || operator == "~=" {
+			return condition{}, fmt.Errorf("operator %s cannot be used with enqueued", operator)
+		}
+
+		return c, nil
+	}
+
+	if _, ok := stringField(c.field, &azservicebus.ReceivedMessage{}); !ok {
+		return condition{}, fmt.Errorf("unknown field %q", c.field)
+	}
+
+	switch operator {
+	case ">", ">=", "<", "<=":
+		return condition{}, fmt.Errorf("operator %s can only be used with enqueued", operator)
+	}
+
+	return c, nil
+}
+
+// parseTime accepts RFC 3339 timestamps, dates, and durations relative to now such as -2h.
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected an RFC 3339 time, a date or a duration relative to now, got %q", value)
+	}
+
+	return time.Now().Add(d), nil
+}
+
+func (c condition) match(msg *azservicebus.ReceivedMessage) bool {
+	if strings.EqualFold(c.field, "enqueued") {
+		if msg.EnqueuedTime == nil {
+			return false
+		}
+
+		switch c.operator {
+		case "=":
+			return msg.EnqueuedTime.Equal(c.time)
+		case "!=":
+			return !msg.EnqueuedTime.Equal(c.time)
+		case ">":
+			return msg.EnqueuedTime.After(c.time)
+		case ">=":
+			return !msg.EnqueuedTime.Before(c.time)
+		case "<":
+			return msg.EnqueuedTime.Before(c.time)
+		case "<=":
+			return !msg.EnqueuedTime.After(c.time)
+		}
+		return false
+	}
+
+	value, _ := stringField(c.field, msg)
+
+	// Conditions on properties the message does not carry only match when they ask for a different value
+	if value == nil {
+		return c.operator == "!="
+	}
+
+	switch c.operator {
+	case "=":
+		return *value == c.value
+	case "!=":
+		return *value != c.value
+	case "^=":
+		return strings.HasPrefix(*value, c.value)
+	case "~=":
+		return strings.Contains(*value, c.value)
+	}
+	return false
+}
+
+// stringField returns the value of a string field of msg, nil when it is not set, and whether the field is known.
+func stringField(field string, msg *azservicebus.ReceivedMessage) (*string, bool) {
+	if key, ok := strings.CutPrefix(field, applicationPropertyPrefix); ok {
+		value, found := msg.ApplicationProperties[key]
+		if !found {
+			return nil, true
+		}
+		s := fmt.Sprint(value)
+		return &s, true
+	}
+
+	switch strings.ToLower(field) {
+	case "reason":
+		return msg.DeadLetterReason, true
+	case "description":
+		return msg.DeadLetterErrorDescription, true
+	case "source":
+		return msg.DeadLetterSource, true
+	case "subject":
+		return msg.Subject, true
+	case "id", "messageid":
+		return &msg.MessageID, true
+	case "correlationid":
+		return msg.CorrelationID, true
+	case "contenttype":
+		return msg.ContentType, true
+	case "sessionid":
+		return msg.SessionID, true
+	default:
+		return nil, false
+	}
+}
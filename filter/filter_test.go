@@ -0,0 +1,98 @@
+package filter_test
+
+import (
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"service-bus-hero/filter"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	enqueued := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	recent := time.Now().Add(-time.Hour)
+
+	msg := &azservicebus.ReceivedMessage{
+		MessageID:        "order-1",
+		Subject:          ptr("OrderCreated"),
+		DeadLetterReason: ptr("MaxDeliveryCountExceeded, retried"),
+		EnqueuedTime:     &enqueued,
+		ApplicationProperties: map[string]any{
+			"tenant":  "acme",
+			"attempt": 3,
+		},
+	}
+	recentMsg := &azservicebus.ReceivedMessage{MessageID: "order-2", EnqueuedTime: &recent}
+
+	tests := []struct {
+		expression string
+		msg        *azservicebus.ReceivedMessage
+		want       bool
+	}{
+		{"id=order-1", msg, true},
+		{"messageid=order-2", msg, false},
+		{"ID=order-1", msg, true},
+		{"id!=order-2", msg, true},
+		{"subject^=Order", msg, true},
+		{"subject^=Invoice", msg, false},
+		{"subject~=Created", msg, true},
+		{" subject = OrderCreated ", msg, true},
+		{`reason="MaxDeliveryCountExceeded, retried"`, msg, true},
+		{"reason=MaxDeliveryCountExceeded", msg, false},
+		{"correlationid=x", msg, false},
+		{"correlationid!=x", msg, true},
+		{"app.tenant=acme", msg, true},
+		{"app.attempt=3", msg, true},
+		{"app.region=eu", msg, false},
+		{"app.region!=eu", msg, true},
+		{"app.tenant=acme, subject^=Order", msg, true},
+		{"app.tenant=acme, subject^=Invoice", msg, false},
+		{"enqueued=2024-05-01T12:00:00Z", msg, true},
+		{"enqueued>=2024-05-01", msg, true},
+		{"enqueued<2024-05-01", msg, false},
+		{"enqueued>2024-05-01T12:00:00Z", msg, false},
+		{"enqueued<=2024-05-01T12:00:00Z", msg, true},
+		{"Enqueued>=2024-05-01", msg, true},
+		{"enqueued>=-2h", recentMsg, true},
+		{"enqueued>=-30m", recentMsg, false},
+		{"enqueued>=-2h", &azservicebus.ReceivedMessage{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			f, err := filter.Parse(test.expression)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", test.expression, err)
+			}
+
+			if got := f.Match(test.msg); got != test.want {
+				t.Errorf("Parse(%q).Match(%s) = %v, want %v", test.expression, test.msg.MessageID, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		" , ",
+		"subject",
+		"=OrderCreated",
+		"colour=red",
+		"subject>Order",
+		"enqueued^=2024",
+		"ENQUEUED~=2024",
+		"enqueued>=yesterday",
+	}
+
+	for _, expression := range tests {
+		t.Run(expression, func(t *testing.T) {
+			if _, err := filter.Parse(expression); err == nil {
+				t.Errorf("Parse(%q) succeeded, want an error", expression)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
@@ -0,0 +1,87 @@
+package io
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	BodyEncodingUTF8   = "utf8"
+	BodyEncodingBase64 = "base64"
+)
+
+// binaryContentTypes are media types, or suffixes of them, whose payloads are never written as text
+// even when the bytes happen to be valid UTF-8.
+var binaryContentTypes = []string{
+	"application/octet-stream",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/avro",
+	"application/protobuf",
+	"application/x-protobuf",
+	"application/vnd.google.protobuf",
+	"application/vnd.apache.avro+binary",
+	"+avro",
+	"+protobuf",
+	"image/",
+	"audio/",
+	"video/",
+}
+
+// EncodeBody picks the encoding a message body is stored with in a JSONL export: UTF-8 text when the
+// body is valid UTF-8 and its content type is not a binary one, base64 otherwise.
+func EncodeBody(body []byte, contentType *string) (string, string) {
+	if utf8.Valid(body) && !isBinaryContentType(contentType) {
+		return string(body), BodyEncodingUTF8
+	}
+
+	return base64.StdEncoding.EncodeToString(body), BodyEncodingBase64
+}
+
+// DecodeBody restores the bytes of a body stored by EncodeBody. Files written before encodings were
+// recorded have no encoding and hold text.
+func DecodeBody(body string, encoding string) ([]byte, error) {
+	switch encoding {
+	case "", BodyEncodingUTF8:
+		return []byte(body), nil
+	case BodyEncodingBase64:
+		return base64.StdEncoding.DecodeString(body)
+	default:
+		return nil, fmt.Errorf("unknown body encoding %q", encoding)
+	}
+}
+
+func isBinaryContentType(contentType *string) bool {
+	if contentType == nil || *contentType == "" {
+		return false
+	}
+
+	mediaType, params, err := mime.ParseMediaType(*contentType)
+	if err != nil {
+		mediaType = strings.ToLower(*contentType)
+	}
+
+	// Content-Encoding style parameters such as "application/json; encoding=gzip" make any payload binary
+	if encoding, ok := params["encoding"]; ok && encoding != "utf-8" && encoding != "utf8" {
+		return true
+	}
+
+	for _, binary := range binaryContentTypes {
+		if strings.HasPrefix(binary, "+") || strings.HasSuffix(binary, "/") {
+			if strings.HasSuffix(mediaType, binary) || strings.HasPrefix(mediaType, binary) {
+				return true
+			}
+			continue
+		}
+
+		if mediaType == binary {
+			return true
+		}
+	}
+
+	return false
+}
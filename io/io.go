@@ -1,9 +1,13 @@
 package io
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"io"
 	"os"
 	"time"
 )
@@ -11,6 +15,7 @@ import (
 type SerializableMessage struct {
 	ApplicationProperties      map[string]interface{} `json:"applicationProperties"`
 	Body                       string                 `json:"body"`
+	BodyEncoding               string                 `json:"bodyEncoding,omitempty"`
 	ContentType                *string                `json:"contentType"`
 	CorrelationID              *string                `json:"correlationID"`
 	DeadLetterErrorDescription *string                `json:"deadLetterErrorDescription"`
@@ -60,7 +65,42 @@ func ListJsonlFiles() ([]string, error) {
 	return jsonlFiles, nil
 }
 
-func WriteMessagesToJsonLinesFile(messagesChan <-chan *azservicebus.ReceivedMessage, filename string) (int, error) {
+// WriteMessages writes every message of messagesChan with writer, closes it and returns how many were
+// written. When a message cannot be written abort is called with the error, so the producer can stop, and
+// the rest of messagesChan is drained so the producer is never left blocked.
+func WriteMessages(writer *MessageWriter, messagesChan <-chan *azservicebus.ReceivedMessage, abort func(error)) (int, error) {
+	if err := writeAll(writer, messagesChan); err != nil {
+		abort(err)
+		for range messagesChan {
+		}
+
+		_ = writer.file.Close()
+		return 0, err
+	}
+
+	return writer.Count(), nil
+}
+
+func writeAll(writer *MessageWriter, messagesChan <-chan *azservicebus.ReceivedMessage) error {
+	for receivedMsg := range messagesChan {
+		if err := writer.Write(receivedMsg); err != nil {
+			return err
+		}
+	}
+
+	// Every message received has to reach the file, also when the fetch was interrupted
+	return writer.Close()
+}
+
+// MessageWriter writes received messages to a JSON lines file, one message per line.
+type MessageWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+	count  int
+}
+
+// CreateMessageWriter creates filename, and its parent directories if they don't exist.
+func CreateMessageWriter(filename string) (*MessageWriter, error) {
 	// Create parent directories if they don't exist
 	dir := ""
 	lastSlashIndex := -1
@@ -74,84 +114,92 @@ func WriteMessagesToJsonLinesFile(messagesChan <-chan *azservicebus.ReceivedMess
 	if lastSlashIndex != -1 {
 		dir = filename[:lastSlashIndex]
 		if err := os.MkdirAll(dir, 0755); err != nil {
-			return 0, fmt.Errorf("failed to create directories: %w", err)
+			return nil, fmt.Errorf("failed to create directories: %w", err)
 		}
 	}
 
 	// Open file for writing
 	file, err := os.Create(filename)
 	if err != nil {
-		return 0, fmt.Errorf("failed to create file: %w", err)
+		return nil, fmt.Errorf("failed to create file: %w", err)
 	}
-	defer file.Close()
 
-	i := 0
+	return &MessageWriter{file: file, writer: bufio.NewWriter(file)}, nil
+}
 
-	for receivedMsg := range messagesChan {
-		i++
-
-		// Convert each ReceivedMessage to a SerializableMessage
-		message := SerializableMessage{
-			ApplicationProperties:      receivedMsg.ApplicationProperties,
-			Body:                       string(receivedMsg.Body),
-			ContentType:                receivedMsg.ContentType,
-			CorrelationID:              receivedMsg.CorrelationID,
-			DeadLetterErrorDescription: receivedMsg.DeadLetterErrorDescription,
-			DeadLetterReason:           receivedMsg.DeadLetterReason,
-			DeadLetterSource:           receivedMsg.DeadLetterSource,
-			DeliveryCount:              receivedMsg.DeliveryCount,
-			EnqueuedSequenceNumber:     receivedMsg.EnqueuedSequenceNumber,
-			EnqueuedTime:               receivedMsg.EnqueuedTime,
-			ExpiresAt:                  receivedMsg.ExpiresAt,
-			LockedUntil:                receivedMsg.LockedUntil,
-			LockToken:                  receivedMsg.LockToken,
-			MessageID:                  receivedMsg.MessageID,
-			PartitionKey:               receivedMsg.PartitionKey,
-			ReplyTo:                    receivedMsg.ReplyTo,
-			ReplyToSessionID:           receivedMsg.ReplyToSessionID,
-			ScheduledEnqueueTime:       receivedMsg.ScheduledEnqueueTime,
-			SequenceNumber:             receivedMsg.SequenceNumber,
-			SessionID:                  receivedMsg.SessionID,
-			State:                      stateToString(int(receivedMsg.State)),
-			Subject:                    receivedMsg.Subject,
-			TimeToLive:                 receivedMsg.TimeToLive,
-			To:                         receivedMsg.To,
-		}
+func (w *MessageWriter) Write(receivedMsg *azservicebus.ReceivedMessage) error {
+	message := NewSerializableMessage(receivedMsg)
 
-		// Serialize the SerializableMessage to JSON
-		jsonBytes, err := json.Marshal(message)
-		if err != nil {
-			return 0, fmt.Errorf("failed to serialize message: %w", err)
-		}
+	// Serialize the SerializableMessage to JSON
+	jsonBytes, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to serialize message: %w", err)
+	}
 
-		// Write the JSON bytes to the file, followed by a newline to separate JSON objects
-		if _, err := file.Write(jsonBytes); err != nil {
-			return 0, fmt.Errorf("failed to write message to file: %w", err)
-		}
-		if _, err := file.WriteString("\n"); err != nil {
-			return 0, fmt.Errorf("failed to write newline to file: %w", err)
-		}
+	// Write the JSON bytes to the file, followed by a newline to separate JSON objects
+	if _, err := w.writer.Write(jsonBytes); err != nil {
+		return fmt.Errorf("failed to write message to file: %w", err)
+	}
+	if _, err := w.writer.WriteString("\n"); err != nil {
+		return fmt.Errorf("failed to write newline to file: %w", err)
 	}
 
-	return i, nil
+	w.count++
+
+	return nil
 }
 
+// Sync flushes the messages written so far and commits them to disk, so they are kept even if the
+// process dies right after.
+func (w *MessageWriter) Sync() error {
+	if err := w.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush file: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	return nil
+}
+
+// Count returns how many messages have been written.
+func (w *MessageWriter) Count() int {
+	return w.count
+}
+
+func (w *MessageWriter) Name() string {
+	return w.file.Name()
+}
+
+// Close flushes the remaining messages and closes the file.
+func (w *MessageWriter) Close() error {
+	if err := w.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush file: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	return nil
+}
+
+// ReadMessagesFromJsonLinesFile streams the messages of a JSON lines file. At most one error is reported,
+// after which both channels are closed, so the error channel can be read once the messages are drained.
 func ReadMessagesFromJsonLinesFile(filename string) (<-chan *SerializableMessage, <-chan error) {
+	// Create a channel for the messages and errors
+	messageChan := make(chan *SerializableMessage)
+	errorChan := make(chan error, 1)
+
 	// Open file for reading
 	file, err := os.Open(filename)
 	if err != nil {
-		errorChan := make(chan error)
 		errorChan <- fmt.Errorf("failed to open file: %w", err)
 		close(errorChan)
-		return nil, errorChan
+		close(messageChan)
+		return messageChan, errorChan
 	}
 
-	// Create a channel for the messages and errors
-	messageChan := make(chan *SerializableMessage)
-	errorChan := make(chan error)
-
 	// Read the file line by line
 	go func() {
+		defer file.Close()
 		defer close(messageChan)
 		defer close(errorChan)
 
@@ -172,25 +220,165 @@ func ReadMessagesFromJsonLinesFile(filename string) (<-chan *SerializableMessage
 	return messageChan, errorChan
 }
 
-func TransformMessage(msg *SerializableMessage) *azservicebus.Message {
+// LineError is a line of a JSON lines file that cannot be published.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// ValidateJsonLinesFile checks every line of filename the way publishing reads it, without stopping at the
+// first invalid line. It returns how many lines hold a message that can be published and the invalid lines.
+func ValidateJsonLinesFile(filename string) (int, []LineError, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	valid := 0
+	var lineErrors []LineError
+
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var message SerializableMessage
+			if decodeErr := json.Unmarshal(line, &message); decodeErr != nil {
+				lineErrors = append(lineErrors, LineError{lineNumber, fmt.Errorf("failed to decode message: %w", decodeErr)})
+			} else if _, transformErr := TransformMessage(&message); transformErr != nil {
+				lineErrors = append(lineErrors, LineError{lineNumber, transformErr})
+			} else {
+				valid++
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return valid, lineErrors, fmt.Errorf("failed to read file: %w", err)
+		}
+	}
+
+	return valid, lineErrors, nil
+}
+
+// NewSerializableMessage converts a received message into the form it is written to JSON lines files in.
+func NewSerializableMessage(receivedMsg *azservicebus.ReceivedMessage) SerializableMessage {
+	body, bodyEncoding := EncodeBody(receivedMsg.Body, receivedMsg.ContentType)
+
+	return SerializableMessage{
+		ApplicationProperties:      receivedMsg.ApplicationProperties,
+		Body:                       body,
+		BodyEncoding:               bodyEncoding,
+		ContentType:                receivedMsg.ContentType,
+		CorrelationID:              receivedMsg.CorrelationID,
+		DeadLetterErrorDescription: receivedMsg.DeadLetterErrorDescription,
+		DeadLetterReason:           receivedMsg.DeadLetterReason,
+		DeadLetterSource:           receivedMsg.DeadLetterSource,
+		DeliveryCount:              receivedMsg.DeliveryCount,
+		EnqueuedSequenceNumber:     receivedMsg.EnqueuedSequenceNumber,
+		EnqueuedTime:               receivedMsg.EnqueuedTime,
+		ExpiresAt:                  receivedMsg.ExpiresAt,
+		LockedUntil:                receivedMsg.LockedUntil,
+		LockToken:                  receivedMsg.LockToken,
+		MessageID:                  receivedMsg.MessageID,
+		PartitionKey:               receivedMsg.PartitionKey,
+		ReplyTo:                    receivedMsg.ReplyTo,
+		ReplyToSessionID:           receivedMsg.ReplyToSessionID,
+		ScheduledEnqueueTime:       receivedMsg.ScheduledEnqueueTime,
+		SequenceNumber:             receivedMsg.SequenceNumber,
+		SessionID:                  receivedMsg.SessionID,
+		State:                      stateToString(int(receivedMsg.State)),
+		Subject:                    receivedMsg.Subject,
+		TimeToLive:                 receivedMsg.TimeToLive,
+		To:                         receivedMsg.To,
+	}
+}
+
+// TransformReceivedMessage converts a SerializableMessage back into the received message it was written
+// from, including the broker-assigned properties such as the sequence number and dead-letter reason.
+func TransformReceivedMessage(msg *SerializableMessage) (*azservicebus.ReceivedMessage, error) {
+	body, err := DecodeBody(msg.Body, msg.BodyEncoding)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode body of message %s: %w", msg.MessageID, err)
+	}
+
+	return &azservicebus.ReceivedMessage{
+		ApplicationProperties:      msg.ApplicationProperties,
+		Body:                       body,
+		ContentType:                msg.ContentType,
+		CorrelationID:              msg.CorrelationID,
+		DeadLetterErrorDescription: msg.DeadLetterErrorDescription,
+		DeadLetterReason:           msg.DeadLetterReason,
+		DeadLetterSource:           msg.DeadLetterSource,
+		DeliveryCount:              msg.DeliveryCount,
+		EnqueuedSequenceNumber:     msg.EnqueuedSequenceNumber,
+		EnqueuedTime:               msg.EnqueuedTime,
+		ExpiresAt:                  msg.ExpiresAt,
+		LockedUntil:                msg.LockedUntil,
+		LockToken:                  msg.LockToken,
+		MessageID:                  msg.MessageID,
+		PartitionKey:               msg.PartitionKey,
+		ReplyTo:                    msg.ReplyTo,
+		ReplyToSessionID:           msg.ReplyToSessionID,
+		ScheduledEnqueueTime:       msg.ScheduledEnqueueTime,
+		SequenceNumber:             msg.SequenceNumber,
+		SessionID:                  msg.SessionID,
+		State:                      stateFromString(msg.State),
+		Subject:                    msg.Subject,
+		TimeToLive:                 msg.TimeToLive,
+		To:                         msg.To,
+	}, nil
+}
+
+// TransformMessage converts a SerializableMessage back into a message that can be sent, keeping every
+// property that can be set on an outgoing message.
+func TransformMessage(msg *SerializableMessage) (*azservicebus.Message, error) {
+	body, err := DecodeBody(msg.Body, msg.BodyEncoding)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode body of message %s: %w", msg.MessageID, err)
+	}
+
 	// Convert the SerializableMessage to a Message
 	message := azservicebus.Message{
-		Body: []byte(msg.Body),
+		Body:                 body,
+		ContentType:          nonEmpty(msg.ContentType),
+		CorrelationID:        nonEmpty(msg.CorrelationID),
+		PartitionKey:         nonEmpty(msg.PartitionKey),
+		ReplyTo:              nonEmpty(msg.ReplyTo),
+		ReplyToSessionID:     nonEmpty(msg.ReplyToSessionID),
+		ScheduledEnqueueTime: msg.ScheduledEnqueueTime,
+		Subject:              nonEmpty(msg.Subject),
+		TimeToLive:           msg.TimeToLive,
+		To:                   nonEmpty(msg.To),
 	}
 
-	if msg.Subject != nil && *msg.Subject != "" {
-		message.Subject = msg.Subject
+	if msg.MessageID != "" {
+		message.MessageID = &msg.MessageID
 	}
 
-	if msg.CorrelationID != nil && *msg.CorrelationID != "" {
-		message.CorrelationID = msg.CorrelationID
+	// An empty session ID is valid for session-aware entities, so it is kept as is
+	if msg.SessionID != nil {
+		message.SessionID = msg.SessionID
 	}
 
 	if msg.ApplicationProperties != nil {
 		message.ApplicationProperties = msg.ApplicationProperties
 	}
 
-	return &message
+	return &message, nil
+}
+
+func nonEmpty(value *string) *string {
+	if value == nil || *value == "" {
+		return nil
+	}
+	return value
 }
 
 func stateToString(state int) string {
@@ -205,3 +393,14 @@ func stateToString(state int) string {
 		return "Unknown"
 	}
 }
+
+func stateFromString(state string) azservicebus.MessageState {
+	switch state {
+	case "Deferred":
+		return azservicebus.MessageStateDeferred
+	case "Scheduled":
+		return azservicebus.MessageStateScheduled
+	default:
+		return azservicebus.MessageStateActive
+	}
+}
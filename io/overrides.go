@@ -0,0 +1,153 @@
+package io
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"strings"
+	"time"
+)
+
+// applicationPropertyPrefix addresses an application property instead of a system property in overrides.
+const applicationPropertyPrefix = "app."
+
+// Properties lists the settable message properties by their SerializableMessage JSON names.
+var Properties = []string{
+	"messageID",
+	"contentType",
+	"correlationID",
+	"sessionID",
+	"partitionKey",
+	"replyTo",
+	"replyToSessionID",
+	"subject",
+	"to",
+	"timeToLive",
+	"scheduledEnqueueTime",
+}
+
+// MessageOverrides strips or replaces individual properties of messages before they are sent.
+type MessageOverrides struct {
+	setters      []func(*azservicebus.Message)
+	descriptions []string
+	// properties holds the lower-cased names of the overridden properties.
+	properties map[string]bool
+}
+
+// ParseMessageOverrides builds overrides from property names to strip and "name=value" pairs to set.
+// Application properties are addressed as "app.<name>".
+func ParseMessageOverrides(strip []string, set []string) (*MessageOverrides, error) {
+	overrides := &MessageOverrides{properties: make(map[string]bool)}
+
+	for _, name := range strip {
+		setter, err := propertySetter(name, nil)
+		if err != nil {
+			return nil, err
+		}
+		overrides.setters = append(overrides.setters, setter)
+		overrides.descriptions = append(overrides.descriptions, "strip "+name)
+		overrides.properties[strings.ToLower(name)] = true
+	}
+
+	for _, pair := range set {
+		name, value, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid property override %q, expected name=value", pair)
+		}
+
+		setter, err := propertySetter(name, &value)
+		if err != nil {
+			return nil, err
+		}
+		overrides.setters = append(overrides.setters, setter)
+		overrides.descriptions = append(overrides.descriptions, "set "+pair)
+		overrides.properties[strings.ToLower(name)] = true
+	}
+
+	return overrides, nil
+}
+
+// String lists the overrides as they were given, e.g. "strip messageID, set to=invoices-v2".
+func (o *MessageOverrides) String() string {
+	if o == nil {
+		return ""
+	}
+	return strings.Join(o.descriptions, ", ")
+}
+
+// Overrides reports whether the named property is stripped or replaced.
+func (o *MessageOverrides) Overrides(name string) bool {
+	return o != nil && o.properties[strings.ToLower(name)]
+}
+
+func (o *MessageOverrides) Apply(msg *azservicebus.Message) {
+	if o == nil {
+		return
+	}
+
+	for _, setter := range o.setters {
+		setter(msg)
+	}
+}
+
+// propertySetter returns a function that sets the named property to value, or clears it when value is nil.
+func propertySetter(name string, value *string) (func(*azservicebus.Message), error) {
+	if key, ok := strings.CutPrefix(name, applicationPropertyPrefix); ok {
+		return func(msg *azservicebus.Message) {
+			properties := make(map[string]any, len(msg.ApplicationProperties)+1)
+			for k, v := range msg.ApplicationProperties {
+				properties[k] = v
+			}
+
+			if value == nil {
+				delete(properties, key)
+			} else {
+				properties[key] = *value
+			}
+
+			msg.ApplicationProperties = properties
+		}, nil
+	}
+
+	switch strings.ToLower(name) {
+	case "messageid":
+		return func(msg *azservicebus.Message) { msg.MessageID = value }, nil
+	case "contenttype":
+		return func(msg *azservicebus.Message) { msg.ContentType = value }, nil
+	case "correlationid":
+		return func(msg *azservicebus.Message) { msg.CorrelationID = value }, nil
+	case "sessionid":
+		return func(msg *azservicebus.Message) { msg.SessionID = value }, nil
+	case "partitionkey":
+		return func(msg *azservicebus.Message) { msg.PartitionKey = value }, nil
+	case "replyto":
+		return func(msg *azservicebus.Message) { msg.ReplyTo = value }, nil
+	case "replytosessionid":
+		return func(msg *azservicebus.Message) { msg.ReplyToSessionID = value }, nil
+	case "subject":
+		return func(msg *azservicebus.Message) { msg.Subject = value }, nil
+	case "to":
+		return func(msg *azservicebus.Message) { msg.To = value }, nil
+	case "timetolive":
+		var ttl *time.Duration
+		if value != nil {
+			d, err := time.ParseDuration(*value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeToLive %q: %w", *value, err)
+			}
+			ttl = &d
+		}
+		return func(msg *azservicebus.Message) { msg.TimeToLive = ttl }, nil
+	case "scheduledenqueuetime":
+		var scheduled *time.Time
+		if value != nil {
+			t, err := time.Parse(time.RFC3339, *value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid scheduledEnqueueTime %q: %w", *value, err)
+			}
+			scheduled = &t
+		}
+		return func(msg *azservicebus.Message) { msg.ScheduledEnqueueTime = scheduled }, nil
+	default:
+		return nil, fmt.Errorf("unknown message property %q, expected one of %s or %s<name>", name, strings.Join(Properties, ", "), applicationPropertyPrefix)
+	}
+}
@@ -1,42 +1,71 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
 	"github.com/joho/godotenv"
+	"io/fs"
 	"log"
 	"os"
+	"os/signal"
 	"service-bus-hero/prompts"
+	"service-bus-hero/topics"
+	"syscall"
+	"time"
 )
 
-var appContext = &AppContext{}
+var appContext = &AppContext{Concurrency: topics.DefaultConcurrency}
 
-func listCommands() {
-	commands := []prompts.Command{
+// menuCommands lists the actions of the interactive menu. Actions return to the menu when they are done;
+// Exit ends it by returning prompts.ErrExit.
+func menuCommands(recent *history) []prompts.Command {
+	return []prompts.Command{
 		{
 			Name:        "Topic stats",
 			Description: "List stats for all topics.",
-			Action: func() error {
-				err := ListTopicStatByTopics()
+			Action: func(ctx context.Context) error {
+				err := ListTopicStatByTopics(ctx, "table")
 				if err != nil {
 					return fmt.Errorf("could not list topic stats: %w", err)
 				}
 
-				listCommands()
+				return nil
+			},
+		},
+		{
+			Name:        "Queue stats",
+			Description: "List stats for all queues.",
+			Action: func(ctx context.Context) error {
+				err := ListQueueStats(ctx, "table")
+				if err != nil {
+					return fmt.Errorf("could not list queue stats: %w", err)
+				}
 
 				return nil
 			},
 		},
 		{
 			Name:        "DLQ stats",
-			Description: "List stats for subscriptions with DLQ messages.",
-			Action: func() error {
-				err := ListDLQStats()
+			Description: "List stats for subscriptions and queues with DLQ messages.",
+			Action: func(ctx context.Context) error {
+				err := ListDLQStats(ctx, "table")
 				if err != nil {
 					return fmt.Errorf("could not list DLQ stats: %w", err)
 				}
 
-				listCommands()
+				return nil
+			},
+		},
+		{
+			Name:        "Watch DLQ stats",
+			Description: "Refreshes DLQ and backlog stats every 10 seconds until Ctrl+C is pressed.",
+			Action: func(ctx context.Context) error {
+				err := WatchStats(ctx, 10*time.Second, true)
+				if err != nil {
+					return fmt.Errorf("could not watch DLQ stats: %w", err)
+				}
 
 				return nil
 			},
@@ -44,43 +73,72 @@ func listCommands() {
 		{
 			Name:        "Select Topic",
 			Description: "Selects a topic to work with.",
-			Action: func() error {
-				err := SelectTopic()
+			Action: func(ctx context.Context) error {
+				err := SelectTopic(ctx)
 				if err != nil {
 					return fmt.Errorf("could not select topic: %w", err)
 				}
 
 				fmt.Printf("Selected topic: %s\n", appContext.Topic)
-				listCommands()
-
 				return nil
 			},
 		},
 		{
 			Name:        "Select Subscription",
 			Description: "Selects a subscription to work with.",
-			Action: func() error {
-				err := SelectSubscription()
+			Action: func(ctx context.Context) error {
+				err := SelectSubscription(ctx)
 				if err != nil {
 					return fmt.Errorf("could not select subscription: %w", err)
 				}
 
 				fmt.Printf("Selected subscription: %s\n", appContext.Subscription)
-				listCommands()
+				return nil
+			},
+		},
+		{
+			Name:        "Select Queue",
+			Description: "Selects a queue to work with.",
+			Action: func(ctx context.Context) error {
+				err := SelectQueue(ctx)
+				if err != nil {
+					return fmt.Errorf("could not select queue: %w", err)
+				}
 
+				fmt.Printf("Selected queue: %s\n", appContext.Queue)
 				return nil
 			},
 		},
 		{
-			Name:        "Download DLQ Messages (PeekLock)",
-			Description: "Downloads messages in peek-lock mode",
-			Action: func() error {
-				err := WriteDLQMessagesToFile(azservicebus.ReceiveModePeekLock)
+			Name:        "Browse DLQ Messages (Peek)",
+			Description: "Downloads DLQ messages without locking them or changing their delivery count.",
+			Action: func(ctx context.Context) error {
+				messageFilter, err := PromptFilter()
+				if err != nil {
+					return err
+				}
+
+				err = BrowseMessagesToFile(ctx, true, "", messageFilter)
 				if err != nil {
 					return fmt.Errorf("could not write DLQ messages to file: %w", err)
 				}
 
-				listCommands()
+				return nil
+			},
+		},
+		{
+			Name:        "Browse Messages (Peek)",
+			Description: "Downloads active messages without locking them or changing their delivery count.",
+			Action: func(ctx context.Context) error {
+				messageFilter, err := PromptFilter()
+				if err != nil {
+					return err
+				}
+
+				err = BrowseMessagesToFile(ctx, false, "", messageFilter)
+				if err != nil {
+					return fmt.Errorf("could not write messages to file: %w", err)
+				}
 
 				return nil
 			},
@@ -88,95 +146,158 @@ func listCommands() {
 		{
 			Name:        "Download DLQ Messages (ReceiveAndDelete)",
 			Description: "Downloads messages from DLQ and __REMOVES__ them from the queue.",
-			Action: func() error {
-				err := WriteDLQMessagesToFile(azservicebus.ReceiveModeReceiveAndDelete)
+			Mutating:    true,
+			Action: func(ctx context.Context) error {
+				messageFilter, err := PromptFilter()
 				if err != nil {
-					return fmt.Errorf("could not write DLQ messages to file: %w", err)
+					return err
 				}
 
-				listCommands()
+				err = WriteDLQMessagesToFile(ctx, azservicebus.ReceiveModeReceiveAndDelete, "", messageFilter)
+				if err != nil {
+					return fmt.Errorf("could not write DLQ messages to file: %w", err)
+				}
 
 				return nil
 			},
 		},
 		{
-			Name:        "Publish Messages to topic",
-			Description: "Publishes messages to a topic.",
-			Action: func() error {
-				err := PublishMessages()
+			Name:        "Publish Messages",
+			Description: "Publishes messages to the selected queue or topic.",
+			Mutating:    true,
+			Action: func(ctx context.Context) error {
+				err := PublishMessages(ctx, "", nil)
 				if err != nil {
 					return fmt.Errorf("could not publish messages: %w", err)
 				}
 
-				listCommands()
-
 				return nil
 			},
 		},
 		{
 			Name:        "Resend All DLQ Messages",
-			Description: "Resends all DLQ messages from all subscriptions back to their topics.",
-			Action: func() error {
-				err := ResendAllDLQMessages()
+			Description: "Resends all DLQ messages from all subscriptions and queues back to their topics and queues.",
+			Mutating:    true,
+			Action: func(ctx context.Context) error {
+				options, err := PromptResendOptions()
 				if err != nil {
-					return fmt.Errorf("could not resend all DLQ messages: %w", err)
+					return err
 				}
 
-				listCommands()
+				err = ResendAllDLQMessages(ctx, options)
+				if err != nil {
+					return fmt.Errorf("could not resend all DLQ messages: %w", err)
+				}
 
 				return nil
 			},
 		},
 		{
 			Name:        "Clear All DLQ Messages",
-			Description: "Clears (deletes) all DLQ messages from all subscriptions.",
-			Action: func() error {
-				err := ClearAllDLQMessages()
+			Description: "Clears (deletes) all DLQ messages from all subscriptions and queues.",
+			Mutating:    true,
+			Action: func(ctx context.Context) error {
+				err := ClearAllDLQMessages(ctx)
 				if err != nil {
 					return fmt.Errorf("could not clear all DLQ messages: %w", err)
 				}
 
-				listCommands()
+				return nil
+			},
+		},
+		{
+			Name:        "Toggle Dry Run",
+			Description: "Previews resends, clears and publishing instead of running them.",
+			Action: func(ctx context.Context) error {
+				appContext.DryRun = !appContext.DryRun
 
 				return nil
 			},
 		},
 		{
-			Name:        "Change Connection String",
-			Description: "Changes the connection string.",
-			Action: func() error {
-				GetConnectionString()
+			Name:        "Switch Profile",
+			Description: "Connects to another profile of the config file or to a connection string.",
+			Action: func(ctx context.Context) error {
+				err := SwitchProfile()
+				if err != nil {
+					return fmt.Errorf("could not switch profile: %w", err)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:        recentActionsName,
+			Description: "Shows the actions run in this session and how they ended.",
+			Action: func(ctx context.Context) error {
+				recent.Print(os.Stdout)
+
 				return nil
 			},
 		},
 		{
 			Name:        "Exit",
 			Description: "Exits the application.",
-			Action: func() error {
-				os.Exit(0)
-				return nil
+			Action: func(ctx context.Context) error {
+				return prompts.ErrExit
 			},
 		},
 	}
+}
 
-	fmt.Println("")
-	PrintContext(appContext)
-	prompts.PromptCommandList(commands)
+// interruptible returns a context that is cancelled by the first Ctrl+C or SIGTERM, so long operations stop
+// at the next batch boundary and report what they did. Later signals are no longer caught, so a second
+// Ctrl+C terminates the process right away.
+func interruptible(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	return ctx, stop
 }
 
 func processEnv() {
-	// Load the .env file
+	// Load the .env file, which is optional when profiles are used
 	err := godotenv.Load() // This will look for a ".env" file in the current directory
-	if err != nil {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
 
 	appContext.ConnectionString = os.Getenv("SBHERO_CONNECTION_STRING")
+	appContext.Namespace = os.Getenv("SBHERO_NAMESPACE")
+	appContext.TenantID = os.Getenv("SBHERO_TENANT_ID")
+	appContext.ClientID = os.Getenv("SBHERO_CLIENT_ID")
+	appContext.ClientSecret = os.Getenv("SBHERO_CLIENT_SECRET")
+
+	if authMethod := os.Getenv("SBHERO_AUTH"); authMethod != "" {
+		method, err := topics.ParseAuthMethod(authMethod)
+		if err != nil {
+			log.Fatalf("Invalid SBHERO_AUTH: %v", err)
+		}
+
+		appContext.AuthMethod = method
+	}
 	appContext.Topic = os.Getenv("SBHERO_TOPIC")
+	appContext.Subscription = os.Getenv("SBHERO_SUBSCRIPTION")
+	appContext.Queue = os.Getenv("SBHERO_QUEUE")
+
+	if appContext.Queue != "" && appContext.Topic == "" {
+		appContext.EntityKind = topics.EntityKindQueue
+	}
 }
 
 func main() {
 	processEnv()
-	GetConnectionString()
-	listCommands()
+
+	ctx, stop := interruptible(context.Background())
+	err := newRootCommand().ExecuteContext(ctx)
+	stop()
+	appContext.Close()
+
+	if err != nil {
+		os.Exit(exitCode(err))
+	}
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"service-bus-hero/prompts"
+	"service-bus-hero/topics"
+	"time"
+)
+
+const (
+	// historySize is how many actions the menu remembers.
+	historySize = 20
+	// recentActionsName is the menu entry that shows the history; it is not recorded itself.
+	recentActionsName = "Recent Actions"
+)
+
+type historyEntry struct {
+	at       time.Time
+	name     string
+	duration time.Duration
+	err      error
+}
+
+// history keeps the most recent actions run from the menu, oldest first.
+type history struct {
+	entries []historyEntry
+}
+
+func (h *history) add(entry historyEntry) {
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > historySize {
+		h.entries = h.entries[len(h.entries)-historySize:]
+	}
+}
+
+func (h *history) Print(w io.Writer) {
+	if len(h.entries) == 0 {
+		fmt.Fprintln(w, "No actions run yet")
+		return
+	}
+
+	for _, entry := range h.entries {
+		fmt.Fprintf(w, "%s  %s: %s (%s)\n", entry.at.Format(time.TimeOnly), entry.name, outcome(entry.err), entry.duration.Round(time.Millisecond))
+	}
+}
+
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "done"
+	case errors.Is(err, prompts.ErrBack):
+		return "cancelled"
+	case errors.Is(err, topics.ErrInterrupted):
+		return "interrupted"
+	default:
+		return "failed: " + err.Error()
+	}
+}
+
+// visibleCommands leaves out mutating commands in read-only mode and returns the position of the command
+// named last, or 0 when it is not shown.
+func visibleCommands(all []prompts.Command, last string) ([]prompts.Command, int) {
+	var commands []prompts.Command
+	cursor := 0
+
+	for _, command := range all {
+		if command.Mutating && !appContext.Writable() {
+			continue
+		}
+		if command.Name == last {
+			cursor = len(commands)
+		}
+		commands = append(commands, command)
+	}
+
+	return commands, cursor
+}
+
+// runMenu runs the interactive menu until Exit is picked or it is left with Ctrl+C or Ctrl+D. A failing
+// action is reported and the menu is shown again, Esc in a prompt of an action goes back to the menu, and
+// Ctrl+C while an action runs stops only that action. Commands that change messages are hidden in read-only mode.
+func runMenu() error {
+	recent := &history{}
+	all := menuCommands(recent)
+	last := ""
+
+	for {
+		fmt.Println("")
+		PrintContext(appContext)
+
+		// Switching profiles can turn read-only mode on or off, so the visible commands are picked every time
+		commands, cursor := visibleCommands(all, last)
+
+		i, err := prompts.PromptCommandList(commands, cursor)
+		if errors.Is(err, prompts.ErrExit) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		command := commands[i]
+		last = command.Name
+
+		ctx, stop := interruptible(context.Background())
+		started := time.Now()
+		err = command.Action(ctx)
+		stop()
+
+		if errors.Is(err, prompts.ErrExit) {
+			return nil
+		}
+
+		if command.Name == recentActionsName {
+			continue
+		}
+
+		recent.add(historyEntry{
+			at:       started,
+			name:     command.Name,
+			duration: time.Since(started),
+			err:      err,
+		})
+
+		switch {
+		case err == nil:
+		case errors.Is(err, prompts.ErrBack):
+			fmt.Println("Cancelled")
+		case errors.Is(err, topics.ErrInterrupted):
+			fmt.Println("Interrupted")
+		default:
+			fmt.Printf("Command failed: %v\n", err)
+		}
+	}
+}
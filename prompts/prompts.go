@@ -1,15 +1,137 @@
 package prompts
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/manifoldco/promptui"
+	"io"
 	"os"
+	"sync"
+	"sync/atomic"
 )
 
+// ErrBack is returned by every prompt when it is left with Esc, Ctrl+C or Ctrl+D, to go back to the menu.
+var ErrBack = errors.New("back")
+
+// ErrExit is returned by PromptCommandList when the menu is left with Ctrl+C or Ctrl+D.
+var ErrExit = errors.New("exit")
+
 type Command struct {
 	Name        string
 	Description string
-	Action      func() error
+	// Mutating commands send, resend or remove messages and are hidden in read-only mode.
+	Mutating bool
+	Action   func(ctx context.Context) error
+}
+
+// terminalInput is the stdin shared by all prompts. promptui reads stdin through a goroutine that stays
+// blocked in Read after its prompt is done and then swallows the next key press, so stdin is read by one
+// goroutine instead and reads of a finished prompt are released empty when the next prompt starts.
+//
+// It also turns a lone Esc key press into Ctrl+C, which promptui already stops on, and remembers it so Esc
+// can be told apart from Ctrl+C. Escape sequences of arrow and function keys arrive in one read and are
+// passed on unchanged.
+type terminalInput struct {
+	once    sync.Once
+	chunks  chan []byte
+	mu      sync.Mutex
+	pending []byte
+	done    chan struct{}
+	escape  atomic.Bool
+}
+
+var stdin = &terminalInput{done: make(chan struct{})}
+
+// next releases the reads of previous prompts and clears the Esc flag before a prompt starts.
+func (t *terminalInput) next() {
+	t.once.Do(func() {
+		t.chunks = make(chan []byte)
+		go t.pump()
+	})
+
+	t.mu.Lock()
+	close(t.done)
+	t.done = make(chan struct{})
+	t.mu.Unlock()
+
+	t.escape.Store(false)
+}
+
+func (t *terminalInput) pump() {
+	buf := make([]byte, 1024)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n > 0 {
+			t.chunks <- append([]byte(nil), buf[:n]...)
+		}
+		if err != nil {
+			close(t.chunks)
+			return
+		}
+	}
+}
+
+func (t *terminalInput) Read(p []byte) (int, error) {
+	t.mu.Lock()
+	done := t.done
+	if len(t.pending) > 0 {
+		n := copy(p, t.pending)
+		t.pending = t.pending[n:]
+		t.mu.Unlock()
+		return n, nil
+	}
+	t.mu.Unlock()
+
+	var chunk []byte
+	select {
+	case <-done:
+		return 0, nil
+	case c, ok := <-t.chunks:
+		if !ok {
+			return 0, io.EOF
+		}
+		chunk = c
+	}
+
+	if len(chunk) == 1 && chunk[0] == 27 {
+		chunk[0] = 3
+		t.escape.Store(true)
+	}
+
+	n := copy(p, chunk)
+
+	t.mu.Lock()
+	t.pending = append(t.pending, chunk[n:]...)
+	t.mu.Unlock()
+
+	return n, nil
+}
+
+func (t *terminalInput) Close() error {
+	return nil
+}
+
+func runPrompt(prompt *promptui.Prompt) (string, error) {
+	stdin.next()
+	prompt.Stdin = stdin
+	result, err := prompt.Run()
+	return result, promptError(err)
+}
+
+func runSelect(prompt *promptui.Select) (int, string, error) {
+	stdin.next()
+	prompt.Stdin = stdin
+	i, result, err := prompt.Run()
+	return i, result, promptError(err)
+}
+
+// promptError maps leaving a prompt with Esc, Ctrl+C or Ctrl+D to ErrBack.
+func promptError(err error) error {
+	if errors.Is(err, promptui.ErrInterrupt) || errors.Is(err, promptui.ErrEOF) {
+		return ErrBack
+	}
+	return err
 }
 
 func PromptConnectionString() (string, error) {
@@ -17,7 +139,48 @@ func PromptConnectionString() (string, error) {
 		Label: "Enter connection string",
 	}
 
-	result, err := prompt.Run()
+	result, err := runPrompt(&prompt)
+	if err != nil {
+		return "", fmt.Errorf("prompt failed: %w", err)
+	}
+
+	return result, nil
+}
+
+func PromptSelectProfile(profiles []string) (string, error) {
+	prompt := promptui.Select{
+		Label: "Select a profile",
+		Items: profiles,
+	}
+
+	_, result, err := runSelect(&prompt)
+	if err != nil {
+		return "", fmt.Errorf("prompt failed: %w", err)
+	}
+
+	return result, nil
+}
+
+// PromptConfirmation asks for the namespace name before a destructive action; the caller compares it.
+func PromptConfirmation(namespace string) (string, error) {
+	prompt := promptui.Prompt{
+		Label: fmt.Sprintf("Namespace name (%s)", namespace),
+	}
+
+	result, err := runPrompt(&prompt)
+	if err != nil {
+		return "", fmt.Errorf("prompt failed: %w", err)
+	}
+
+	return result, nil
+}
+
+func PromptNamespace() (string, error) {
+	prompt := promptui.Prompt{
+		Label: "Enter namespace (e.g. my-namespace.servicebus.windows.net)",
+	}
+
+	result, err := runPrompt(&prompt)
 	if err != nil {
 		return "", fmt.Errorf("prompt failed: %w", err)
 	}
@@ -31,7 +194,7 @@ func PromptSelectTopic(topics []string) (string, error) {
 		Items: topics,
 	}
 
-	_, result, err := prompt.Run()
+	_, result, err := runSelect(&prompt)
 	if err != nil {
 		return "", fmt.Errorf("prompt failed: %w", err)
 	}
@@ -45,7 +208,41 @@ func PromptSelectSubscription(subscriptions []string) (string, error) {
 		Items: subscriptions,
 	}
 
-	_, result, err := prompt.Run()
+	_, result, err := runSelect(&prompt)
+	if err != nil {
+		return "", fmt.Errorf("prompt failed: %w", err)
+	}
+
+	return result, nil
+}
+
+func PromptSelectQueue(queues []string) (string, error) {
+	prompt := promptui.Select{
+		Label: "Select a queue",
+		Items: queues,
+	}
+
+	_, result, err := runSelect(&prompt)
+	if err != nil {
+		return "", fmt.Errorf("prompt failed: %w", err)
+	}
+
+	return result, nil
+}
+
+func PromptSelectRedeliveryMode() (string, error) {
+	prompt := promptui.Select{
+		Label: "Redeliver subscription DLQ messages to",
+		Items: []string{"subscription", "topic"},
+		Templates: &promptui.SelectTemplates{
+			Active:   "\U0001F449 {{ . | cyan }}",
+			Inactive: "  {{ . | cyan }}",
+			Selected: "\U0001F3C1 {{ . | cyan }}",
+			Details:  `{{ if eq . "topic" }}Every subscription on the topic whose rules match receives a copy.{{ else }}Only the subscription that dead-lettered the message receives it.{{ end }}`,
+		},
+	}
+
+	_, result, err := runSelect(&prompt)
 	if err != nil {
 		return "", fmt.Errorf("prompt failed: %w", err)
 	}
@@ -53,10 +250,13 @@ func PromptSelectSubscription(subscriptions []string) (string, error) {
 	return result, nil
 }
 
-func PromptCommandList(commands []Command) error {
+// PromptCommandList shows the menu with the cursor on the command at cursor and returns the index of the
+// picked command. Esc keeps the menu open, Ctrl+C and Ctrl+D return ErrExit.
+func PromptCommandList(commands []Command, cursor int) (int, error) {
 	prompt := promptui.Select{
 		Label: "Select Command",
 		Items: commands,
+		Size:  5,
 		Templates: &promptui.SelectTemplates{
 			Label:    "{{ . }}?",
 			Active:   "\U0001F449 {{ .Name | cyan }} ({{ .Description | red }})",
@@ -65,20 +265,24 @@ func PromptCommandList(commands []Command) error {
 		},
 	}
 
-	i, _, err := prompt.Run()
-
-	if err != nil {
-		fmt.Printf("Prompt failed %v\n", err)
-		os.Exit(1)
-	}
+	for {
+		stdin.next()
+		prompt.Stdin = stdin
+		// Scroll so the cursor is the last visible command when it is below the first page
+		i, _, err := prompt.RunCursorAt(cursor, max(0, cursor-prompt.Size+1))
+		escape := stdin.escape.Load()
 
-	err = commands[i].Action()
-	if err != nil {
-		fmt.Printf("Command failed %v\n", err)
-		os.Exit(1)
+		switch {
+		case err == nil:
+			return i, nil
+		case escape:
+			continue
+		case errors.Is(err, promptui.ErrInterrupt), errors.Is(err, promptui.ErrEOF):
+			return 0, ErrExit
+		default:
+			return 0, fmt.Errorf("prompt failed: %w", err)
+		}
 	}
-
-	return nil
 }
 
 func PromptFileName(defaultValue *string) (string, error) {
@@ -90,7 +294,20 @@ func PromptFileName(defaultValue *string) (string, error) {
 		prompt.Default = *defaultValue
 	}
 
-	result, err := prompt.Run()
+	result, err := runPrompt(&prompt)
+	if err != nil {
+		return "", fmt.Errorf("prompt failed: %w", err)
+	}
+
+	return result, nil
+}
+
+func PromptFilterExpression() (string, error) {
+	prompt := promptui.Prompt{
+		Label: "Filter (e.g. reason=MaxDeliveryCountExceeded, enqueued>=-2h), empty for all messages",
+	}
+
+	result, err := runPrompt(&prompt)
 	if err != nil {
 		return "", fmt.Errorf("prompt failed: %w", err)
 	}
@@ -104,7 +321,7 @@ func SelectFileOrCustom(filenames []string) (string, error) {
 		Items: filenames,
 	}
 
-	_, result, err := prompt.Run()
+	_, result, err := runSelect(&prompt)
 	if err != nil {
 		return "", fmt.Errorf("prompt failed: %w", err)
 	}
@@ -117,7 +334,7 @@ func EnterCustomFileName() (string, error) {
 		Label: "Enter a custom file name",
 	}
 
-	result, err := prompt.Run()
+	result, err := runPrompt(&prompt)
 	if err != nil {
 		return "", fmt.Errorf("prompt failed: %w", err)
 	}
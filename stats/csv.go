@@ -0,0 +1,65 @@
+package stats
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// CSVRenderer writes one record per row with the JSON field names as header.
+type CSVRenderer struct{}
+
+var csvHeader = []string{
+	"kind",
+	"topic",
+	"subscription",
+	"queue",
+	"activeMessages",
+	"deadLetterMessages",
+	"transferDeadLetterMessages",
+	"scheduledMessages",
+	"sizeInBytes",
+	"accessedAt",
+	"updatedAt",
+	"error",
+}
+
+func (CSVRenderer) Render(w io.Writer, snapshot *Snapshot) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, row := range snapshot.Rows {
+		record := []string{
+			string(row.Kind),
+			row.Topic,
+			row.Subscription,
+			row.Queue,
+			strconv.Itoa(int(row.ActiveMessages)),
+			strconv.Itoa(int(row.DeadLetterMessages)),
+			strconv.Itoa(int(row.TransferDeadLetterMessages)),
+			strconv.Itoa(int(row.ScheduledMessages)),
+			strconv.FormatInt(row.SizeInBytes, 10),
+			csvTime(row.AccessedAt),
+			csvTime(row.UpdatedAt),
+			row.Error,
+		}
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
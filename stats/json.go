@@ -0,0 +1,14 @@
+package stats
+
+import (
+	"encoding/json"
+	"io"
+)
+
+type JSONRenderer struct{}
+
+func (JSONRenderer) Render(w io.Writer, snapshot *Snapshot) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(snapshot)
+}
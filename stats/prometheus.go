@@ -0,0 +1,90 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PrometheusRenderer writes the snapshot in the Prometheus text exposition format. Every metric family
+// covers a single kind of entity so that all of its samples carry the same labels.
+type PrometheusRenderer struct{}
+
+type metric struct {
+	name  string
+	help  string
+	kind  Kind
+	value func(Row) float64
+}
+
+var metrics = []metric{
+	{"sbhero_topic_scheduled_messages", "Number of scheduled messages in the topic.", KindTopic, func(r Row) float64 { return float64(r.ScheduledMessages) }},
+	{"sbhero_topic_size_bytes", "Size of the topic in bytes.", KindTopic, func(r Row) float64 { return float64(r.SizeInBytes) }},
+	{"sbhero_subscription_active_messages", "Number of active messages in the subscription.", KindSubscription, func(r Row) float64 { return float64(r.ActiveMessages) }},
+	{"sbhero_subscription_dead_letter_messages", "Number of messages in the dead-letter queue of the subscription.", KindSubscription, func(r Row) float64 { return float64(r.DeadLetterMessages) }},
+	{"sbhero_subscription_transfer_dead_letter_messages", "Number of messages in the transfer dead-letter queue of the subscription.", KindSubscription, func(r Row) float64 { return float64(r.TransferDeadLetterMessages) }},
+	{"sbhero_queue_active_messages", "Number of active messages in the queue.", KindQueue, func(r Row) float64 { return float64(r.ActiveMessages) }},
+	{"sbhero_queue_dead_letter_messages", "Number of messages in the dead-letter queue of the queue.", KindQueue, func(r Row) float64 { return float64(r.DeadLetterMessages) }},
+	{"sbhero_queue_transfer_dead_letter_messages", "Number of messages in the transfer dead-letter queue of the queue.", KindQueue, func(r Row) float64 { return float64(r.TransferDeadLetterMessages) }},
+	{"sbhero_queue_scheduled_messages", "Number of scheduled messages in the queue.", KindQueue, func(r Row) float64 { return float64(r.ScheduledMessages) }},
+	{"sbhero_queue_size_bytes", "Size of the queue in bytes.", KindQueue, func(r Row) float64 { return float64(r.SizeInBytes) }},
+}
+
+func (PrometheusRenderer) Render(w io.Writer, snapshot *Snapshot) error {
+	for _, m := range metrics {
+		rows := snapshot.rowsOfKind(m.kind)
+		if len(rows) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", m.name)
+
+		for _, row := range rows {
+			if _, err := fmt.Fprintf(w, "%s{%s} %g\n", m.name, labels(snapshot.Namespace, row), m.value(row)); err != nil {
+				return err
+			}
+		}
+	}
+
+	var failed []Row
+	for _, row := range snapshot.rowsOfKind(KindTopic) {
+		if row.Error != "" {
+			failed = append(failed, row)
+		}
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintln(w, "# HELP sbhero_topic_error Set to 1 for topics whose subscriptions could not be listed.")
+		fmt.Fprintln(w, "# TYPE sbhero_topic_error gauge")
+
+		for _, row := range failed {
+			if _, err := fmt.Fprintf(w, "sbhero_topic_error{%s} 1\n", labels(snapshot.Namespace, row)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func labels(namespace string, row Row) string {
+	pairs := []string{label("namespace", namespace)}
+
+	switch row.Kind {
+	case KindTopic:
+		pairs = append(pairs, label("topic", row.Topic))
+	case KindSubscription:
+		pairs = append(pairs, label("topic", row.Topic), label("subscription", row.Subscription))
+	case KindQueue:
+		pairs = append(pairs, label("queue", row.Queue))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(name string, value string) string {
+	return fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(value))
+}
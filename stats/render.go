@@ -0,0 +1,29 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Renderer writes a snapshot in one output format.
+type Renderer interface {
+	Render(w io.Writer, snapshot *Snapshot) error
+}
+
+var Formats = []string{"table", "json", "csv", "prometheus"}
+
+func NewRenderer(format string) (Renderer, error) {
+	switch strings.ToLower(format) {
+	case "", "table":
+		return TableRenderer{}, nil
+	case "json":
+		return JSONRenderer{}, nil
+	case "csv":
+		return CSVRenderer{}, nil
+	case "prometheus", "prom":
+		return PrometheusRenderer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q, expected one of %s", format, strings.Join(Formats, ", "))
+	}
+}
@@ -0,0 +1,122 @@
+package stats
+
+import (
+	"service-bus-hero/topics"
+	"time"
+)
+
+type Kind string
+
+const (
+	KindTopic        Kind = "topic"
+	KindSubscription Kind = "subscription"
+	KindQueue        Kind = "queue"
+)
+
+// Snapshot is the state of a namespace at one point in time, as rendered by every output format.
+type Snapshot struct {
+	Namespace   string    `json:"namespace"`
+	CollectedAt time.Time `json:"collectedAt"`
+	Rows        []Row     `json:"rows"`
+}
+
+// Row holds the runtime properties of a topic, subscription or queue. Topic rows carry the scheduled
+// message count and size shared by their subscriptions, and subscription rows the message counts.
+type Row struct {
+	Kind                       Kind       `json:"kind"`
+	Topic                      string     `json:"topic,omitempty"`
+	Subscription               string     `json:"subscription,omitempty"`
+	Queue                      string     `json:"queue,omitempty"`
+	ActiveMessages             int32      `json:"activeMessages"`
+	DeadLetterMessages         int32      `json:"deadLetterMessages"`
+	TransferDeadLetterMessages int32      `json:"transferDeadLetterMessages"`
+	ScheduledMessages          int32      `json:"scheduledMessages"`
+	SizeInBytes                int64      `json:"sizeInBytes"`
+	AccessedAt                 *time.Time `json:"accessedAt,omitempty"`
+	UpdatedAt                  *time.Time `json:"updatedAt,omitempty"`
+	Error                      string     `json:"error,omitempty"`
+}
+
+func NewSnapshot(namespace string) *Snapshot {
+	return &Snapshot{Namespace: namespace, CollectedAt: time.Now().UTC()}
+}
+
+// AddTopics adds a row for every topic followed by rows for its subscriptions. A topic whose
+// subscriptions could not be listed gets the error on its row.
+func (s *Snapshot) AddTopics(topicStats []topics.TopicStats) {
+	for _, topic := range topicStats {
+		row := Row{
+			Kind:              KindTopic,
+			Topic:             topic.Topic,
+			ScheduledMessages: topic.ScheduledMessageCount,
+			SizeInBytes:       topic.SizeInBytes,
+			AccessedAt:        optionalTime(topic.AccessedAt),
+			UpdatedAt:         optionalTime(topic.UpdatedAt),
+		}
+		if topic.Err != nil {
+			row.Error = topic.Err.Error()
+		}
+		s.Rows = append(s.Rows, row)
+
+		for _, subscription := range topic.Subscriptions {
+			s.Rows = append(s.Rows, entityRow(KindSubscription, subscription))
+		}
+	}
+}
+
+func (s *Snapshot) AddQueues(queueStats []topics.EntityStats) {
+	for _, queue := range queueStats {
+		s.Rows = append(s.Rows, entityRow(KindQueue, queue))
+	}
+}
+
+// DeadLettered returns a copy of the snapshot holding only the subscriptions and queues with
+// dead-lettered messages, and the topics whose subscriptions could not be listed.
+func (s *Snapshot) DeadLettered() *Snapshot {
+	filtered := &Snapshot{Namespace: s.Namespace, CollectedAt: s.CollectedAt}
+
+	for _, row := range s.Rows {
+		if row.Error != "" || (row.Kind != KindTopic && row.DeadLetterMessages > 0) {
+			filtered.Rows = append(filtered.Rows, row)
+		}
+	}
+
+	return filtered
+}
+
+func (s *Snapshot) rowsOfKind(kinds ...Kind) []Row {
+	var rows []Row
+	for _, row := range s.Rows {
+		for _, kind := range kinds {
+			if row.Kind == kind {
+				rows = append(rows, row)
+				break
+			}
+		}
+	}
+	return rows
+}
+
+func entityRow(kind Kind, stats topics.EntityStats) Row {
+	return Row{
+		Kind:                       kind,
+		Topic:                      stats.Entity.Topic,
+		Subscription:               stats.Entity.Subscription,
+		Queue:                      stats.Entity.Queue,
+		ActiveMessages:             stats.ActiveMessageCount,
+		DeadLetterMessages:         stats.DeadLetterMessageCount,
+		TransferDeadLetterMessages: stats.TransferDeadLetterMessageCount,
+		ScheduledMessages:          stats.ScheduledMessageCount,
+		SizeInBytes:                stats.SizeInBytes,
+		AccessedAt:                 optionalTime(stats.AccessedAt),
+		UpdatedAt:                  optionalTime(stats.UpdatedAt),
+	}
+}
+
+// optionalTime drops the zero time the service reports for entities that have never been accessed.
+func optionalTime(t time.Time) *time.Time {
+	if t.Year() <= 1 {
+		return nil
+	}
+	return &t
+}
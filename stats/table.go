@@ -0,0 +1,99 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// TableRenderer prints the topic and subscription rows and the queue rows as two aligned tables.
+type TableRenderer struct{}
+
+func (TableRenderer) Render(w io.Writer, snapshot *Snapshot) error {
+	topicRows := snapshot.rowsOfKind(KindTopic, KindSubscription)
+	queueRows := snapshot.rowsOfKind(KindQueue)
+
+	if len(topicRows) > 0 || len(queueRows) == 0 {
+		if err := renderTopicTable(w, topicRows); err != nil {
+			return err
+		}
+	}
+
+	if len(queueRows) > 0 {
+		if len(topicRows) > 0 {
+			fmt.Fprintln(w, "")
+		}
+
+		if err := renderQueueTable(w, queueRows); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func renderTopicTable(out io.Writer, rows []Row) error {
+	w := tabwriter.NewWriter(out, 0, 8, 4, '\t', 0)
+	fmt.Fprintln(w, "Topic\tSubscription\tActive Messages\tDLQ Messages\tTransfer DLQ\tScheduled\tSize\tAccessed\tUpdated\t")
+
+	for _, row := range rows {
+		switch {
+		case row.Error != "":
+			fmt.Fprintf(w, "%s\t%s\terror: %s\t\t\t\t\t\t\t\n", row.Topic, row.Subscription, row.Error)
+		case row.Kind == KindTopic:
+			fmt.Fprintf(w, "%s\t\t\t\t\t%d\t%s\t%s\t%s\t\n", row.Topic, row.ScheduledMessages, formatSize(row.SizeInBytes), formatTime(row.AccessedAt), formatTime(row.UpdatedAt))
+		default:
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t\t\t%s\t%s\t\n", row.Topic, row.Subscription, row.ActiveMessages, row.DeadLetterMessages, row.TransferDeadLetterMessages, formatTime(row.AccessedAt), formatTime(row.UpdatedAt))
+		}
+	}
+
+	// Ensure all data is flushed to the output
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("could not flush writer: %w", err)
+	}
+
+	return nil
+}
+
+func renderQueueTable(out io.Writer, rows []Row) error {
+	w := tabwriter.NewWriter(out, 0, 8, 4, '\t', 0)
+	fmt.Fprintln(w, "Queue\tActive Messages\tDLQ Messages\tTransfer DLQ\tScheduled\tSize\tAccessed\tUpdated\t")
+
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t\n", row.Queue, row.ActiveMessages, row.DeadLetterMessages, row.TransferDeadLetterMessages, row.ScheduledMessages, formatSize(row.SizeInBytes), formatTime(row.AccessedAt), formatTime(row.UpdatedAt))
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("could not flush writer: %w", err)
+	}
+
+	return nil
+}
+
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	value := float64(bytes)
+	suffixes := []string{"KB", "MB", "GB", "TB"}
+	suffix := ""
+	for _, suffix = range suffixes {
+		value /= unit
+		if value < unit {
+			break
+		}
+	}
+
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
+
+// formatTime prints times in local time, and a dash for entities that have never been accessed.
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
@@ -0,0 +1,110 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Every row starts with an escape sequence of the same length so tabwriter keeps the columns aligned
+// whether a row is highlighted or not.
+const (
+	highlightStart = "\033[1;33m"
+	plainStart     = "\033[0;39m"
+	styleReset     = "\033[0m"
+)
+
+// DeltaRenderer renders the subscriptions and queues of consecutive snapshots as one table with the
+// change of the active and dead-letter counts since the previous snapshot, and their rate per minute.
+// Rows whose counts changed are highlighted.
+type DeltaRenderer struct {
+	// DLQOnly limits the table to entities that hold dead-lettered messages now or did in the
+	// previous snapshot, so a drained DLQ still shows up once.
+	DLQOnly  bool
+	previous *Snapshot
+}
+
+type rowKey struct {
+	kind         Kind
+	topic        string
+	subscription string
+	queue        string
+}
+
+func keyOf(row Row) rowKey {
+	return rowKey{row.Kind, row.Topic, row.Subscription, row.Queue}
+}
+
+func (r *DeltaRenderer) Render(out io.Writer, snapshot *Snapshot) error {
+	previousRows := make(map[rowKey]Row)
+	var elapsed time.Duration
+
+	if r.previous != nil {
+		for _, row := range r.previous.Rows {
+			previousRows[keyOf(row)] = row
+		}
+		elapsed = snapshot.CollectedAt.Sub(r.previous.CollectedAt)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "%sEntity\tKind\tActive\tΔ Active\tActive/min\tDLQ\tΔ DLQ\tDLQ/min\t%s\n", plainStart, styleReset)
+
+	for _, row := range snapshot.Rows {
+		if row.Kind == KindTopic {
+			if row.Error != "" {
+				fmt.Fprintf(w, "%s%s\t%s\terror: %s\t\t\t\t\t\t%s\n", highlightStart, row.Topic, row.Kind, row.Error, styleReset)
+			}
+			continue
+		}
+
+		previous, seen := previousRows[keyOf(row)]
+
+		if r.DLQOnly && row.DeadLetterMessages == 0 && previous.DeadLetterMessages == 0 {
+			continue
+		}
+
+		style := plainStart
+		activeDelta, activeRate, dlqDelta, dlqRate := "", "", "", ""
+
+		if seen {
+			activeChange := int64(row.ActiveMessages) - int64(previous.ActiveMessages)
+			dlqChange := int64(row.DeadLetterMessages) - int64(previous.DeadLetterMessages)
+
+			if activeChange != 0 || dlqChange != 0 {
+				style = highlightStart
+			}
+
+			activeDelta, activeRate = formatDelta(activeChange), formatRate(activeChange, elapsed)
+			dlqDelta, dlqRate = formatDelta(dlqChange), formatRate(dlqChange, elapsed)
+		}
+
+		fmt.Fprintf(w, "%s%s\t%s\t%d\t%s\t%s\t%d\t%s\t%s\t%s\n", style, entityName(row), row.Kind, row.ActiveMessages, activeDelta, activeRate, row.DeadLetterMessages, dlqDelta, dlqRate, styleReset)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("could not flush writer: %w", err)
+	}
+
+	r.previous = snapshot
+
+	return nil
+}
+
+func entityName(row Row) string {
+	if row.Kind == KindQueue {
+		return row.Queue
+	}
+	return row.Topic + "/" + row.Subscription
+}
+
+func formatDelta(change int64) string {
+	return fmt.Sprintf("%+d", change)
+}
+
+func formatRate(change int64, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%+.1f", float64(change)/elapsed.Minutes())
+}
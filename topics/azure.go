@@ -0,0 +1,259 @@
+package topics
+
+import (
+	"context"
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+)
+
+// azureAdmin implements Admin with the Azure SDK management client.
+type azureAdmin struct {
+	client *admin.Client
+}
+
+func (a azureAdmin) ListTopics(ctx context.Context) ([]string, error) {
+	pager := a.client.NewListTopicsPager(nil)
+
+	var topics []string
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, topic := range page.Topics {
+			topics = append(topics, topic.TopicName)
+		}
+	}
+
+	return topics, nil
+}
+
+func (a azureAdmin) ListSubscriptions(ctx context.Context, topic string) ([]string, error) {
+	pager := a.client.NewListSubscriptionsPager(topic, nil)
+
+	var subscriptions []string
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, subscription := range page.Subscriptions {
+			subscriptions = append(subscriptions, subscription.SubscriptionName)
+		}
+	}
+
+	return subscriptions, nil
+}
+
+func (a azureAdmin) ListQueues(ctx context.Context) ([]string, error) {
+	pager := a.client.NewListQueuesPager(nil)
+
+	var queues []string
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, queue := range page.Queues {
+			queues = append(queues, queue.QueueName)
+		}
+	}
+
+	return queues, nil
+}
+
+func (a azureAdmin) GetTopicRuntimeProperties(ctx context.Context, topic string) (*admin.TopicRuntimeProperties, error) {
+	resp, err := a.client.GetTopicRuntimeProperties(ctx, topic, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, notFound("topic", topic)
+	}
+	return &resp.TopicRuntimeProperties, nil
+}
+
+func (a azureAdmin) GetSubscriptionRuntimeProperties(ctx context.Context, topic string, subscription string) (*admin.SubscriptionRuntimeProperties, error) {
+	resp, err := a.client.GetSubscriptionRuntimeProperties(ctx, topic, subscription, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, notFound("subscription", topic+"/"+subscription)
+	}
+	return &resp.SubscriptionRuntimeProperties, nil
+}
+
+func (a azureAdmin) GetQueueRuntimeProperties(ctx context.Context, queue string) (*admin.QueueRuntimeProperties, error) {
+	resp, err := a.client.GetQueueRuntimeProperties(ctx, queue, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, notFound("queue", queue)
+	}
+	return &resp.QueueRuntimeProperties, nil
+}
+
+func (a azureAdmin) ListTopicsRuntimeProperties(ctx context.Context) ([]admin.TopicRuntimePropertiesItem, error) {
+	pager := a.client.NewListTopicsRuntimePropertiesPager(nil)
+
+	var items []admin.TopicRuntimePropertiesItem
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page.TopicRuntimeProperties...)
+	}
+
+	return items, nil
+}
+
+func (a azureAdmin) ListSubscriptionsRuntimeProperties(ctx context.Context, topic string) ([]admin.SubscriptionRuntimePropertiesItem, error) {
+	pager := a.client.NewListSubscriptionsRuntimePropertiesPager(topic, nil)
+
+	var items []admin.SubscriptionRuntimePropertiesItem
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page.SubscriptionRuntimeProperties...)
+	}
+
+	return items, nil
+}
+
+func (a azureAdmin) ListQueuesRuntimeProperties(ctx context.Context) ([]admin.QueueRuntimePropertiesItem, error) {
+	pager := a.client.NewListQueuesRuntimePropertiesPager(nil)
+
+	var items []admin.QueueRuntimePropertiesItem
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page.QueueRuntimeProperties...)
+	}
+
+	return items, nil
+}
+
+func (a azureAdmin) GetTopic(ctx context.Context, topic string) (*admin.TopicProperties, error) {
+	resp, err := a.client.GetTopic(ctx, topic, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, notFound("topic", topic)
+	}
+	return &resp.TopicProperties, nil
+}
+
+func (a azureAdmin) GetQueue(ctx context.Context, queue string) (*admin.QueueProperties, error) {
+	resp, err := a.client.GetQueue(ctx, queue, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, notFound("queue", queue)
+	}
+	return &resp.QueueProperties, nil
+}
+
+func (a azureAdmin) GetSubscription(ctx context.Context, topic string, subscription string) (*admin.SubscriptionProperties, error) {
+	resp, err := a.client.GetSubscription(ctx, topic, subscription, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, notFound("subscription", topic+"/"+subscription)
+	}
+	return &resp.SubscriptionProperties, nil
+}
+
+func (a azureAdmin) ListRules(ctx context.Context, topic string, subscription string) ([]admin.RuleProperties, error) {
+	pager := a.client.NewListRulesPager(topic, subscription, nil)
+
+	var rules []admin.RuleProperties
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, page.Rules...)
+	}
+
+	return rules, nil
+}
+
+func (a azureAdmin) GetRule(ctx context.Context, topic string, subscription string, rule string) (*admin.RuleProperties, error) {
+	resp, err := a.client.GetRule(ctx, topic, subscription, rule, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, notFound("rule", topic+"/"+subscription+"/"+rule)
+	}
+	return &resp.RuleProperties, nil
+}
+
+func (a azureAdmin) CreateRule(ctx context.Context, topic string, subscription string, rule admin.RuleProperties) error {
+	_, err := a.client.CreateRule(ctx, topic, subscription, &admin.CreateRuleOptions{
+		Name:   &rule.Name,
+		Filter: rule.Filter,
+		Action: rule.Action,
+	})
+	return err
+}
+
+// azureMessaging implements Messaging with the Azure SDK AMQP client.
+type azureMessaging struct {
+	client *azservicebus.Client
+}
+
+func (m azureMessaging) NewReceiver(entity Entity, options *azservicebus.ReceiverOptions) (Receiver, error) {
+	if entity.Kind == EntityKindQueue {
+		return m.client.NewReceiverForQueue(entity.Queue, options)
+	}
+	return m.client.NewReceiverForSubscription(entity.Topic, entity.Subscription, options)
+}
+
+func (m azureMessaging) NewSender(queueOrTopic string) (Sender, error) {
+	sender, err := m.client.NewSender(queueOrTopic, nil)
+	if err != nil {
+		return nil, err
+	}
+	return azureSender{sender}, nil
+}
+
+func (m azureMessaging) Close(ctx context.Context) error {
+	return m.client.Close(ctx)
+}
+
+// azureSender adapts *azservicebus.Sender, whose batches are concrete types, to Sender.
+type azureSender struct {
+	sender *azservicebus.Sender
+}
+
+func (s azureSender) NewMessageBatch(ctx context.Context, options *azservicebus.MessageBatchOptions) (MessageBatch, error) {
+	batch, err := s.sender.NewMessageBatch(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+func (s azureSender) SendMessageBatch(ctx context.Context, batch MessageBatch, options *azservicebus.SendMessageBatchOptions) error {
+	azureBatch, ok := batch.(*azservicebus.MessageBatch)
+	if !ok {
+		return errors.New("message batch was not created by this sender")
+	}
+	return s.sender.SendMessageBatch(ctx, azureBatch, options)
+}
+
+func (s azureSender) Close(ctx context.Context) error {
+	return s.sender.Close(ctx)
+}
@@ -0,0 +1,70 @@
+package topics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+)
+
+// ErrNotFound is wrapped by the errors Admin getters return for an entity or rule that does not exist.
+var ErrNotFound = errors.New("entity not found")
+
+func notFound(kind string, name string) error {
+	return fmt.Errorf("%s %s: %w", kind, name, ErrNotFound)
+}
+
+// Admin is the part of the management API the tool uses. Listings return every page at once, and
+// getters for single entities and rules return an error wrapping ErrNotFound when they do not exist.
+type Admin interface {
+	ListTopics(ctx context.Context) ([]string, error)
+	ListSubscriptions(ctx context.Context, topic string) ([]string, error)
+	ListQueues(ctx context.Context) ([]string, error)
+
+	GetTopicRuntimeProperties(ctx context.Context, topic string) (*admin.TopicRuntimeProperties, error)
+	GetSubscriptionRuntimeProperties(ctx context.Context, topic string, subscription string) (*admin.SubscriptionRuntimeProperties, error)
+	GetQueueRuntimeProperties(ctx context.Context, queue string) (*admin.QueueRuntimeProperties, error)
+
+	ListTopicsRuntimeProperties(ctx context.Context) ([]admin.TopicRuntimePropertiesItem, error)
+	ListSubscriptionsRuntimeProperties(ctx context.Context, topic string) ([]admin.SubscriptionRuntimePropertiesItem, error)
+	ListQueuesRuntimeProperties(ctx context.Context) ([]admin.QueueRuntimePropertiesItem, error)
+
+	GetTopic(ctx context.Context, topic string) (*admin.TopicProperties, error)
+	GetQueue(ctx context.Context, queue string) (*admin.QueueProperties, error)
+	GetSubscription(ctx context.Context, topic string, subscription string) (*admin.SubscriptionProperties, error)
+	ListRules(ctx context.Context, topic string, subscription string) ([]admin.RuleProperties, error)
+	GetRule(ctx context.Context, topic string, subscription string, rule string) (*admin.RuleProperties, error)
+	CreateRule(ctx context.Context, topic string, subscription string, rule admin.RuleProperties) error
+}
+
+// Messaging opens receivers and senders on a namespace.
+type Messaging interface {
+	NewReceiver(entity Entity, options *azservicebus.ReceiverOptions) (Receiver, error)
+	NewSender(queueOrTopic string) (Sender, error)
+	Close(ctx context.Context) error
+}
+
+// Receiver is implemented by *azservicebus.Receiver.
+type Receiver interface {
+	ReceiveMessages(ctx context.Context, maxMessages int, options *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error)
+	PeekMessages(ctx context.Context, maxMessageCount int, options *azservicebus.PeekMessagesOptions) ([]*azservicebus.ReceivedMessage, error)
+	CompleteMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.CompleteMessageOptions) error
+	AbandonMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.AbandonMessageOptions) error
+	DeadLetterMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeadLetterOptions) error
+	RenewMessageLock(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.RenewMessageLockOptions) error
+	Close(ctx context.Context) error
+}
+
+type Sender interface {
+	NewMessageBatch(ctx context.Context, options *azservicebus.MessageBatchOptions) (MessageBatch, error)
+	SendMessageBatch(ctx context.Context, batch MessageBatch, options *azservicebus.SendMessageBatchOptions) error
+	Close(ctx context.Context) error
+}
+
+// MessageBatch is implemented by *azservicebus.MessageBatch. AddMessage returns
+// azservicebus.ErrMessageTooLarge when the batch is full.
+type MessageBatch interface {
+	AddMessage(message *azservicebus.Message, options *azservicebus.AddMessageOptions) error
+	NumMessages() int32
+}
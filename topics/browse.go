@@ -0,0 +1,83 @@
+package topics
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"os"
+)
+
+type BrowseOptions struct {
+	// DeadLetter browses the DLQ of the entity instead of its active messages.
+	DeadLetter bool
+	Filter     MessageFilter
+}
+
+// BrowseMessages pages through an entity with PeekMessages. Nothing is locked or settled, so delivery
+// counts are left unchanged. When ctx is cancelled it stops between pages and reports ErrInterrupted.
+func (c *Client) BrowseMessages(ctx context.Context, entity Entity, options BrowseOptions) (<-chan *azservicebus.ReceivedMessage, <-chan error) {
+	messageChan := make(chan *azservicebus.ReceivedMessage)
+	errorChan := make(chan error, 1) // Buffered channel for at most one error
+
+	go func() {
+		defer close(messageChan)
+		defer close(errorChan)
+
+		receiverOptions := &azservicebus.ReceiverOptions{}
+		if options.DeadLetter {
+			receiverOptions.SubQueue = azservicebus.SubQueueDeadLetter
+		}
+
+		receiver, err := c.newReceiver(entity, receiverOptions)
+		if err != nil {
+			errorChan <- fmt.Errorf("could not create receiver: %w", err)
+			return
+		}
+		defer receiver.Close(context.WithoutCancel(ctx))
+
+		var fromSequenceNumber int64
+		browsedCount := 0
+		matchedCount := 0
+		maxPageSize := 100
+
+		for {
+			if ctx.Err() != nil {
+				errorChan <- interrupted(ctx)
+				return
+			}
+
+			peekedMessages, err := receiver.PeekMessages(ctx, maxPageSize, &azservicebus.PeekMessagesOptions{
+				FromSequenceNumber: &fromSequenceNumber,
+			})
+			if err != nil && ctx.Err() != nil {
+				errorChan <- interrupted(ctx)
+				return
+			}
+			if err != nil {
+				errorChan <- fmt.Errorf("could not peek messages: %w", err)
+				return
+			}
+
+			if len(peekedMessages) == 0 {
+				break
+			}
+
+			for _, msg := range peekedMessages {
+				if options.Filter == nil || options.Filter(msg) {
+					matchedCount++
+					if !handOver(ctx, messageChan, msg) {
+						errorChan <- interrupted(ctx)
+						return
+					}
+				}
+			}
+
+			browsedCount += len(peekedMessages)
+			fromSequenceNumber = *peekedMessages[len(peekedMessages)-1].SequenceNumber + 1
+
+			fmt.Fprintf(os.Stderr, "Browsed %d messages, %d matched\n", browsedCount, matchedCount)
+		}
+	}()
+
+	return messageChan, errorChan
+}
@@ -0,0 +1,55 @@
+package topics
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// Client is a long-lived connection to one namespace. It holds a single admin and a single messaging
+// backend that every operation shares, and has to be closed once it is no longer needed.
+type Client struct {
+	namespace string
+	admin     Admin
+	messaging Messaging
+}
+
+// NewClient connects to an Azure Service Bus namespace.
+func NewClient(creds Credentials) (*Client, error) {
+	adminClient, err := creds.newAdminClient()
+	if err != nil {
+		return nil, fmt.Errorf("could not create service bus admin client: %w", err)
+	}
+
+	messagingClient, err := creds.newClient()
+	if err != nil {
+		return nil, fmt.Errorf("could not create service bus client: %w", err)
+	}
+
+	return NewClientWithBackend(creds.NamespaceName(), azureAdmin{adminClient}, azureMessaging{messagingClient}), nil
+}
+
+// NewClientWithBackend creates a client on top of other implementations of the admin and messaging
+// operations, such as the in-memory namespace in topics/memory.
+func NewClientWithBackend(namespace string, admin Admin, messaging Messaging) *Client {
+	return &Client{namespace: namespace, admin: admin, messaging: messaging}
+}
+
+// NamespaceName returns the fully qualified namespace the client is connected to.
+func (c *Client) NamespaceName() string {
+	return c.namespace
+}
+
+// Close closes the messaging connection along with every sender and receiver still open on it.
+// The admin client talks HTTP and holds nothing that needs closing.
+func (c *Client) Close(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+
+	return c.messaging.Close(ctx)
+}
+
+func (c *Client) newReceiver(entity Entity, options *azservicebus.ReceiverOptions) (Receiver, error) {
+	return c.messaging.NewReceiver(entity, options)
+}
@@ -0,0 +1,140 @@
+package topics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultConcurrency is how many topics have their subscriptions listed at once unless configured otherwise.
+const DefaultConcurrency = 8
+
+// EntityStats holds the runtime properties of one queue or subscription. Subscriptions have no
+// scheduled message count or size of their own; those are kept by their topic.
+type EntityStats struct {
+	Entity                         Entity
+	ActiveMessageCount             int32
+	DeadLetterMessageCount         int32
+	TransferDeadLetterMessageCount int32
+	ScheduledMessageCount          int32
+	SizeInBytes                    int64
+	AccessedAt                     time.Time
+	UpdatedAt                      time.Time
+}
+
+// TopicStats holds the runtime properties of a topic and of its subscriptions, or the error that
+// prevented listing the subscriptions.
+type TopicStats struct {
+	Topic                 string
+	SubscriptionCount     int32
+	ScheduledMessageCount int32
+	SizeInBytes           int64
+	AccessedAt            time.Time
+	UpdatedAt             time.Time
+	Subscriptions         []EntityStats
+	Err                   error
+}
+
+// CollectTopicStats lists the runtime properties of every topic and subscription in the namespace with
+// the bulk pagers: one paged request for the topics and one per topic for its subscriptions, with at most
+// concurrency topics in flight. Topics and subscriptions are in the order the service lists them.
+func (c *Client) CollectTopicStats(ctx context.Context, concurrency int) ([]TopicStats, error) {
+
+	items, err := c.admin.ListTopicsRuntimeProperties(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch topics: %w", err)
+	}
+
+	topicStats := make([]TopicStats, len(items))
+	for i, topic := range items {
+		topicStats[i] = TopicStats{
+			Topic:                 topic.TopicName,
+			SubscriptionCount:     topic.SubscriptionCount,
+			ScheduledMessageCount: topic.ScheduledMessageCount,
+			SizeInBytes:           topic.SizeInBytes,
+			AccessedAt:            topic.AccessedAt,
+			UpdatedAt:             topic.UpdatedAt,
+		}
+	}
+
+	forEach(len(topicStats), concurrency, func(i int) {
+		topicStats[i].Subscriptions, topicStats[i].Err = c.collectSubscriptionStats(ctx, topicStats[i].Topic)
+	})
+
+	return topicStats, nil
+}
+
+func (c *Client) collectSubscriptionStats(ctx context.Context, topic string) ([]EntityStats, error) {
+	items, err := c.admin.ListSubscriptionsRuntimeProperties(ctx, topic)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch subscriptions: %w", err)
+	}
+
+	subscriptionStats := make([]EntityStats, len(items))
+	for i, subscription := range items {
+		subscriptionStats[i] = EntityStats{
+			Entity:                         NewSubscriptionEntity(topic, subscription.SubscriptionName),
+			ActiveMessageCount:             subscription.ActiveMessageCount,
+			DeadLetterMessageCount:         subscription.DeadLetterMessageCount,
+			TransferDeadLetterMessageCount: subscription.TransferDeadLetterMessageCount,
+			AccessedAt:                     subscription.AccessedAt,
+			UpdatedAt:                      subscription.UpdatedAt,
+		}
+	}
+
+	return subscriptionStats, nil
+}
+
+// CollectQueueStats lists the runtime properties of every queue in the namespace with the bulk pager,
+// in the order the service lists them.
+func (c *Client) CollectQueueStats(ctx context.Context) ([]EntityStats, error) {
+	items, err := c.admin.ListQueuesRuntimeProperties(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch queues: %w", err)
+	}
+
+	queueStats := make([]EntityStats, len(items))
+	for i, queue := range items {
+		queueStats[i] = EntityStats{
+			Entity:                         NewQueueEntity(queue.QueueName),
+			ActiveMessageCount:             queue.ActiveMessageCount,
+			DeadLetterMessageCount:         queue.DeadLetterMessageCount,
+			TransferDeadLetterMessageCount: queue.TransferDeadLetterMessageCount,
+			ScheduledMessageCount:          queue.ScheduledMessageCount,
+			SizeInBytes:                    queue.SizeInBytes,
+			AccessedAt:                     queue.AccessedAt,
+			UpdatedAt:                      queue.UpdatedAt,
+		}
+	}
+
+	return queueStats, nil
+}
+
+// forEach calls fn for every index below n from at most concurrency goroutines and waits for all of them.
+// fn writes its result to its own index, which keeps the output order independent of scheduling.
+func forEach(n int, concurrency int, fn func(i int)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < min(concurrency, n); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	wg.Wait()
+}
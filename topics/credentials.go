@@ -0,0 +1,146 @@
+package topics
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+	"strings"
+)
+
+type AuthMethod string
+
+const (
+	AuthConnectionString AuthMethod = "connection-string"
+	AuthDefault          AuthMethod = "default"
+	AuthManagedIdentity  AuthMethod = "managed-identity"
+	AuthServicePrincipal AuthMethod = "service-principal"
+	AuthAzureCLI         AuthMethod = "az-cli"
+)
+
+var AuthMethods = []AuthMethod{AuthConnectionString, AuthDefault, AuthManagedIdentity, AuthServicePrincipal, AuthAzureCLI}
+
+const namespaceSuffix = ".servicebus.windows.net"
+
+// Credentials identify a namespace and how to authenticate against it, either with a
+// connection string or with an Azure AD token credential and the namespace FQDN.
+type Credentials struct {
+	ConnectionString string
+	Namespace        string
+	TokenCredential  azcore.TokenCredential
+}
+
+type AuthOptions struct {
+	Method           AuthMethod
+	ConnectionString string
+	Namespace        string
+	TenantID         string
+	ClientID         string
+	ClientSecret     string
+}
+
+func NewCredentials(opts AuthOptions) (Credentials, error) {
+	if opts.Method == AuthConnectionString {
+		if opts.ConnectionString == "" {
+			return Credentials{}, errors.New("connection string is required")
+		}
+
+		return Credentials{ConnectionString: opts.ConnectionString}, nil
+	}
+
+	if opts.Namespace == "" {
+		return Credentials{}, fmt.Errorf("namespace is required for %s authentication", opts.Method)
+	}
+
+	tokenCredential, err := newTokenCredential(opts)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("could not create %s credential: %w", opts.Method, err)
+	}
+
+	return Credentials{
+		Namespace:       NormalizeNamespace(opts.Namespace),
+		TokenCredential: tokenCredential,
+	}, nil
+}
+
+func newTokenCredential(opts AuthOptions) (azcore.TokenCredential, error) {
+	switch opts.Method {
+	case AuthDefault:
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+			TenantID: opts.TenantID,
+		})
+	case AuthManagedIdentity:
+		options := &azidentity.ManagedIdentityCredentialOptions{}
+		if opts.ClientID != "" {
+			options.ID = azidentity.ClientID(opts.ClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(options)
+	case AuthServicePrincipal:
+		if opts.TenantID == "" || opts.ClientID == "" || opts.ClientSecret == "" {
+			return nil, errors.New("tenant ID, client ID and client secret are required")
+		}
+		return azidentity.NewClientSecretCredential(opts.TenantID, opts.ClientID, opts.ClientSecret, nil)
+	case AuthAzureCLI:
+		return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{
+			TenantID: opts.TenantID,
+		})
+	default:
+		return nil, fmt.Errorf("unknown auth method %q", opts.Method)
+	}
+}
+
+func ParseAuthMethod(method string) (AuthMethod, error) {
+	for _, m := range AuthMethods {
+		if strings.EqualFold(method, string(m)) {
+			return m, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown auth method %q", method)
+}
+
+// NormalizeNamespace turns a bare namespace name into its fully qualified domain name.
+func NormalizeNamespace(namespace string) string {
+	namespace = strings.TrimPrefix(namespace, "sb://")
+	namespace = strings.TrimSuffix(namespace, "/")
+
+	if !strings.Contains(namespace, ".") {
+		namespace += namespaceSuffix
+	}
+
+	return namespace
+}
+
+// NamespaceName returns the fully qualified namespace the credentials point at.
+func (c Credentials) NamespaceName() string {
+	if c.TokenCredential != nil {
+		return c.Namespace
+	}
+
+	for _, part := range strings.Split(c.ConnectionString, ";") {
+		key, value, found := strings.Cut(part, "=")
+		if found && strings.EqualFold(key, "Endpoint") {
+			return NormalizeNamespace(value)
+		}
+	}
+
+	return ""
+}
+
+func (c Credentials) newAdminClient() (*admin.Client, error) {
+	if c.TokenCredential != nil {
+		return admin.NewClient(c.Namespace, c.TokenCredential, nil)
+	}
+
+	return admin.NewClientFromConnectionString(c.ConnectionString, nil)
+}
+
+func (c Credentials) newClient() (*azservicebus.Client, error) {
+	if c.TokenCredential != nil {
+		return azservicebus.NewClient(c.Namespace, c.TokenCredential, nil)
+	}
+
+	return azservicebus.NewClientFromConnectionString(c.ConnectionString, nil)
+}
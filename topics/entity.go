@@ -0,0 +1,57 @@
+package topics
+
+import (
+	"fmt"
+)
+
+type EntityKind int
+
+const (
+	EntityKindSubscription EntityKind = iota
+	EntityKindQueue
+)
+
+func (k EntityKind) String() string {
+	if k == EntityKindQueue {
+		return "queue"
+	}
+	return "subscription"
+}
+
+// Entity is something messages can be received from: a queue or a topic subscription.
+type Entity struct {
+	Kind         EntityKind
+	Queue        string
+	Topic        string
+	Subscription string
+}
+
+func NewQueueEntity(queue string) Entity {
+	return Entity{Kind: EntityKindQueue, Queue: queue}
+}
+
+func NewSubscriptionEntity(topic string, subscription string) Entity {
+	return Entity{Kind: EntityKindSubscription, Topic: topic, Subscription: subscription}
+}
+
+func (e Entity) String() string {
+	if e.Kind == EntityKindQueue {
+		return e.Queue
+	}
+	return fmt.Sprintf("%s/%s", e.Topic, e.Subscription)
+}
+
+// SendTarget is the queue or topic that messages received from the entity are sent back to.
+func (e Entity) SendTarget() string {
+	if e.Kind == EntityKindQueue {
+		return e.Queue
+	}
+	return e.Topic
+}
+
+func (e Entity) IsEmpty() bool {
+	if e.Kind == EntityKindQueue {
+		return e.Queue == ""
+	}
+	return e.Topic == "" || e.Subscription == ""
+}
@@ -0,0 +1,485 @@
+// Package local is a Service Bus namespace persisted to a directory, for rehearsing resends and clears
+// offline against a copy of real dead-lettered messages before running the same commands against the
+// real namespace.
+//
+// The directory holds namespace.json, which describes the queues, topics, subscriptions and rules, and
+// one JSON lines file per message queue in the same format DLQ exports are written in:
+//
+//	queues/<queue>/active.jsonl
+//	queues/<queue>/deadletter.jsonl
+//	topics/<topic>/<subscription>/active.jsonl
+//	topics/<topic>/<subscription>/deadletter.jsonl
+//
+// Messages are held in memory while the namespace is open and written back whenever a receiver or sender
+// is closed, a rule is created and when the namespace is closed.
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+	"net/url"
+	"os"
+	"path/filepath"
+	"service-bus-hero/io"
+	"service-bus-hero/topics"
+	"service-bus-hero/topics/memory"
+	"sync"
+)
+
+const (
+	metadataFile   = "namespace.json"
+	activeFile     = "active.jsonl"
+	deadLetterFile = "deadletter.jsonl"
+)
+
+var (
+	_ topics.Admin     = (*Namespace)(nil)
+	_ topics.Messaging = (*Namespace)(nil)
+)
+
+// Namespace is an in-memory namespace that is loaded from and saved to a directory.
+type Namespace struct {
+	*memory.Namespace
+	dir    string
+	saveMu sync.Mutex
+}
+
+// metadata is the content of namespace.json.
+type metadata struct {
+	Queues []string        `json:"queues"`
+	Topics []topicMetadata `json:"topics"`
+}
+
+type topicMetadata struct {
+	Name          string                 `json:"name"`
+	Subscriptions []subscriptionMetadata `json:"subscriptions"`
+}
+
+type subscriptionMetadata struct {
+	Name      string         `json:"name"`
+	ForwardTo string         `json:"forwardTo,omitempty"`
+	Rules     []ruleMetadata `json:"rules"`
+}
+
+// ruleMetadata stores a rule filter by type: "true", "false", "sql" with an expression, or "correlation".
+type ruleMetadata struct {
+	Name        string                   `json:"name"`
+	Type        string                   `json:"type"`
+	Expression  string                   `json:"expression,omitempty"`
+	Correlation *admin.CorrelationFilter `json:"correlation,omitempty"`
+}
+
+// Open loads the namespace stored in dir, or starts an empty one when the directory holds none yet.
+func Open(dir string) (*Namespace, error) {
+	n := &Namespace{Namespace: memory.New(dir), dir: dir}
+
+	data, err := os.ReadFile(filepath.Join(dir, metadataFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return n, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read local namespace: %w", err)
+	}
+
+	var meta metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", filepath.Join(dir, metadataFile), err)
+	}
+
+	for _, queue := range meta.Queues {
+		if err := n.CreateQueue(queue); err != nil {
+			return nil, err
+		}
+		if err := n.load(topics.NewQueueEntity(queue)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, t := range meta.Topics {
+		if err := n.CreateTopic(t.Name); err != nil {
+			return nil, err
+		}
+
+		for _, sub := range t.Subscriptions {
+			if err := n.loadSubscription(t.Name, sub); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// Name identifies the directory the namespace is stored in.
+func (n *Namespace) Name() string {
+	return "local:" + n.dir
+}
+
+func (n *Namespace) Dir() string {
+	return n.dir
+}
+
+// EnsureEntity creates a queue, or a topic and subscription, unless it already exists.
+func (n *Namespace) EnsureEntity(entity topics.Entity) error {
+	ctx := context.Background()
+
+	if entity.Kind == topics.EntityKindQueue {
+		queues, err := n.ListQueues(ctx)
+		if err != nil {
+			return err
+		}
+		if contains(queues, entity.Queue) {
+			return nil
+		}
+		return n.CreateQueue(entity.Queue)
+	}
+
+	topicNames, err := n.ListTopics(ctx)
+	if err != nil {
+		return err
+	}
+	if !contains(topicNames, entity.Topic) {
+		if err := n.CreateTopic(entity.Topic); err != nil {
+			return err
+		}
+	}
+
+	subscriptions, err := n.ListSubscriptions(ctx, entity.Topic)
+	if err != nil {
+		return err
+	}
+	if contains(subscriptions, entity.Subscription) {
+		return nil
+	}
+	return n.CreateSubscription(entity.Topic, entity.Subscription)
+}
+
+// Import reads a JSON lines export and restores its messages into the active or dead-lettered messages of
+// entity, keeping the properties they were received with. It returns the number of messages imported.
+func (n *Namespace) Import(entity topics.Entity, deadLetter bool, fileName string) (int, error) {
+	messages, err := readMessages(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := n.Restore(entity, deadLetter, messages...); err != nil {
+		return 0, err
+	}
+
+	return len(messages), n.Save()
+}
+
+func (n *Namespace) NewReceiver(entity topics.Entity, options *azservicebus.ReceiverOptions) (topics.Receiver, error) {
+	r, err := n.Namespace.NewReceiver(entity, options)
+	if err != nil {
+		return nil, err
+	}
+	return &receiver{Receiver: r, namespace: n}, nil
+}
+
+func (n *Namespace) NewSender(queueOrTopic string) (topics.Sender, error) {
+	s, err := n.Namespace.NewSender(queueOrTopic)
+	if err != nil {
+		return nil, err
+	}
+	return &sender{Sender: s, namespace: n}, nil
+}
+
+func (n *Namespace) CreateRule(ctx context.Context, topicName string, name string, rule admin.RuleProperties) error {
+	if err := n.Namespace.CreateRule(ctx, topicName, name, rule); err != nil {
+		return err
+	}
+	return n.Save()
+}
+
+// Close saves the namespace.
+func (n *Namespace) Close(ctx context.Context) error {
+	return n.Save()
+}
+
+// receiver saves the namespace when it is closed, so settled messages are persisted.
+type receiver struct {
+	topics.Receiver
+	namespace *Namespace
+}
+
+func (r *receiver) Close(ctx context.Context) error {
+	if err := r.Receiver.Close(ctx); err != nil {
+		return err
+	}
+	return r.namespace.Save()
+}
+
+// sender saves the namespace when it is closed, so sent messages are persisted.
+type sender struct {
+	topics.Sender
+	namespace *Namespace
+}
+
+func (s *sender) Close(ctx context.Context) error {
+	if err := s.Sender.Close(ctx); err != nil {
+		return err
+	}
+	return s.namespace.Save()
+}
+
+func (n *Namespace) loadSubscription(topicName string, sub subscriptionMetadata) error {
+	if err := n.CreateSubscription(topicName, sub.Name); err != nil {
+		return err
+	}
+
+	if sub.ForwardTo != "" {
+		if err := n.SetForwardTo(topicName, sub.Name, sub.ForwardTo); err != nil {
+			return err
+		}
+	}
+
+	rules := make([]admin.RuleProperties, 0, len(sub.Rules))
+	for _, rule := range sub.Rules {
+		filter, err := rule.filter()
+		if err != nil {
+			return fmt.Errorf("subscription %s/%s: %w", topicName, sub.Name, err)
+		}
+		rules = append(rules, admin.RuleProperties{Name: rule.Name, Filter: filter})
+	}
+
+	if err := n.ReplaceRules(topicName, sub.Name, rules...); err != nil {
+		return err
+	}
+
+	return n.load(topics.NewSubscriptionEntity(topicName, sub.Name))
+}
+
+// load restores the messages of an entity from its JSON lines files.
+func (n *Namespace) load(entity topics.Entity) error {
+	for _, deadLetter := range []bool{false, true} {
+		fileName := n.messagesFile(entity, deadLetter)
+
+		if _, err := os.Stat(fileName); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		messages, err := readMessages(fileName)
+		if err != nil {
+			return err
+		}
+
+		if err := n.Restore(entity, deadLetter, messages...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Save writes the namespace and all of its messages to the directory. Each file is replaced atomically.
+func (n *Namespace) Save() error {
+	n.saveMu.Lock()
+	defer n.saveMu.Unlock()
+
+	ctx := context.Background()
+	var meta metadata
+	var entities []topics.Entity
+
+	queues, err := n.ListQueues(ctx)
+	if err != nil {
+		return err
+	}
+	meta.Queues = queues
+	for _, queue := range queues {
+		entities = append(entities, topics.NewQueueEntity(queue))
+	}
+
+	topicNames, err := n.ListTopics(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, topicName := range topicNames {
+		t := topicMetadata{Name: topicName}
+
+		subscriptions, err := n.ListSubscriptions(ctx, topicName)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range subscriptions {
+			sub, err := n.subscriptionMetadata(ctx, topicName, name)
+			if err != nil {
+				return err
+			}
+			t.Subscriptions = append(t.Subscriptions, sub)
+			entities = append(entities, topics.NewSubscriptionEntity(topicName, name))
+		}
+
+		meta.Topics = append(meta.Topics, t)
+	}
+
+	for _, entity := range entities {
+		for _, deadLetter := range []bool{false, true} {
+			messages, err := n.Messages(entity, deadLetter)
+			if err != nil {
+				return err
+			}
+			if err := writeMessages(n.messagesFile(entity, deadLetter), messages); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not serialize local namespace: %w", err)
+	}
+
+	return writeFile(filepath.Join(n.dir, metadataFile), append(data, '\n'))
+}
+
+func (n *Namespace) subscriptionMetadata(ctx context.Context, topicName string, name string) (subscriptionMetadata, error) {
+	sub := subscriptionMetadata{Name: name}
+
+	props, err := n.GetSubscription(ctx, topicName, name)
+	if err != nil {
+		return sub, err
+	}
+	if props.ForwardTo != nil {
+		sub.ForwardTo = *props.ForwardTo
+	}
+
+	rules, err := n.ListRules(ctx, topicName, name)
+	if err != nil {
+		return sub, err
+	}
+
+	for _, rule := range rules {
+		r, err := newRuleMetadata(rule)
+		if err != nil {
+			return sub, fmt.Errorf("subscription %s/%s: %w", topicName, name, err)
+		}
+		sub.Rules = append(sub.Rules, r)
+	}
+
+	return sub, nil
+}
+
+// messagesFile returns the path of the active or dead-letter file of an entity. Entity names are escaped,
+// since queue and topic names may contain slashes.
+func (n *Namespace) messagesFile(entity topics.Entity, deadLetter bool) string {
+	fileName := activeFile
+	if deadLetter {
+		fileName = deadLetterFile
+	}
+
+	if entity.Kind == topics.EntityKindQueue {
+		return filepath.Join(n.dir, "queues", url.PathEscape(entity.Queue), fileName)
+	}
+	return filepath.Join(n.dir, "topics", url.PathEscape(entity.Topic), url.PathEscape(entity.Subscription), fileName)
+}
+
+func newRuleMetadata(rule admin.RuleProperties) (ruleMetadata, error) {
+	r := ruleMetadata{Name: rule.Name}
+
+	switch filter := rule.Filter.(type) {
+	case *admin.TrueFilter:
+		r.Type = "true"
+	case *admin.FalseFilter:
+		r.Type = "false"
+	case *admin.SQLFilter:
+		r.Type = "sql"
+		r.Expression = filter.Expression
+	case *admin.CorrelationFilter:
+		r.Type = "correlation"
+		r.Correlation = filter
+	default:
+		return r, fmt.Errorf("rule %s has an unsupported filter %T", rule.Name, rule.Filter)
+	}
+
+	return r, nil
+}
+
+func (r ruleMetadata) filter() (admin.RuleFilter, error) {
+	switch r.Type {
+	case "true":
+		return &admin.TrueFilter{}, nil
+	case "false":
+		return &admin.FalseFilter{}, nil
+	case "sql":
+		return &admin.SQLFilter{Expression: r.Expression}, nil
+	case "correlation":
+		if r.Correlation == nil {
+			return &admin.CorrelationFilter{}, nil
+		}
+		return r.Correlation, nil
+	default:
+		return nil, fmt.Errorf("rule %s has an unknown filter type %q", r.Name, r.Type)
+	}
+}
+
+func readMessages(fileName string) ([]*azservicebus.ReceivedMessage, error) {
+	messagesChan, errChan := io.ReadMessagesFromJsonLinesFile(fileName)
+
+	var messages []*azservicebus.ReceivedMessage
+	var transformErr error
+
+	for msg := range messagesChan {
+		received, err := io.TransformReceivedMessage(msg)
+		if err != nil && transformErr == nil {
+			transformErr = err
+		}
+		messages = append(messages, received)
+	}
+
+	if err := <-errChan; err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", fileName, err)
+	}
+	if transformErr != nil {
+		return nil, fmt.Errorf("could not read %s: %w", fileName, transformErr)
+	}
+
+	return messages, nil
+}
+
+func writeMessages(fileName string, messages []*azservicebus.ReceivedMessage) error {
+	var data []byte
+
+	for _, msg := range messages {
+		line, err := json.Marshal(io.NewSerializableMessage(msg))
+		if err != nil {
+			return fmt.Errorf("could not serialize message %s: %w", msg.MessageID, err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	return writeFile(fileName, data)
+}
+
+// writeFile replaces a file through a temporary file, so an interrupted save never leaves it half written.
+func writeFile(fileName string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	tmp := fileName + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("could not write %s: %w", fileName, err)
+	}
+
+	if err := os.Rename(tmp, fileName); err != nil {
+		return fmt.Errorf("could not write %s: %w", fileName, err)
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package local_test
+
+import (
+	"context"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+	"reflect"
+	"service-bus-hero/topics"
+	"service-bus-hero/topics/local"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	invoices := topics.NewQueueEntity("invoices")
+	billing := topics.NewSubscriptionEntity("orders", "billing")
+	rules := []admin.RuleProperties{
+		{Name: "guard", Filter: &admin.SQLFilter{Expression: "[" + topics.RedeliveryProperty + "] IS NULL"}},
+		{Name: "acme", Filter: &admin.CorrelationFilter{ApplicationProperties: map[string]any{"tenant": "acme"}}},
+	}
+
+	ns, err := local.Open(dir)
+	must(t, err)
+	must(t, ns.CreateQueue("invoices"))
+	must(t, ns.CreateTopic("orders"))
+	must(t, ns.CreateSubscription("orders", "billing"))
+	must(t, ns.CreateSubscription("orders", "audit"))
+	must(t, ns.CreateSubscription("orders", "archive"))
+	must(t, ns.ReplaceRules("orders", "audit", rules...))
+	must(t, ns.SetForwardTo("orders", "archive", "invoices"))
+
+	must(t, ns.Send("orders", message("a", "acme"), message("b", "globex")))
+	must(t, ns.AddDeadLetter(billing, message("c", "acme"), "MaxDeliveryCountExceeded", "gave up"))
+	must(t, ns.Close(context.Background()))
+
+	reopened, err := local.Open(dir)
+	must(t, err)
+
+	for _, entity := range []topics.Entity{invoices, billing, topics.NewSubscriptionEntity("orders", "audit")} {
+		for _, deadLetter := range []bool{false, true} {
+			want, err := ns.Messages(entity, deadLetter)
+			must(t, err)
+			got, err := reopened.Messages(entity, deadLetter)
+			must(t, err)
+
+			if len(got) != len(want) {
+				t.Fatalf("%s has %d messages (dead-letter %v) after reopening, want %d", entity, len(got), deadLetter, len(want))
+			}
+			for i := range want {
+				assertMessage(t, got[i], want[i])
+			}
+		}
+	}
+
+	gotRules, err := reopened.ListRules(context.Background(), "orders", "audit")
+	must(t, err)
+	if !reflect.DeepEqual(gotRules, rules) {
+		t.Errorf("audit has rules %+v after reopening, want %+v", gotRules, rules)
+	}
+
+	archive, err := reopened.GetSubscription(context.Background(), "orders", "archive")
+	must(t, err)
+	if archive.ForwardTo == nil || *archive.ForwardTo != "invoices" {
+		t.Errorf("archive forwards to %v after reopening, want invoices", archive.ForwardTo)
+	}
+
+	// Sequence numbers continue after the restored messages instead of starting over
+	highest := highestSequenceNumber(t, reopened, billing)
+	must(t, reopened.Send("orders", message("d", "acme")))
+	if next := highestSequenceNumber(t, reopened, billing); next <= highest {
+		t.Errorf("message sent after reopening has sequence number %d, want more than %d", next, highest)
+	}
+}
+
+func TestSettledMessagesPersist(t *testing.T) {
+	dir := t.TempDir()
+	queue := topics.NewQueueEntity("invoices")
+
+	ns, err := local.Open(dir)
+	must(t, err)
+	must(t, ns.CreateQueue("invoices"))
+	must(t, ns.AddDeadLetter(queue, message("a", "acme"), "reason", "description"))
+	must(t, ns.AddDeadLetter(queue, message("b", "acme"), "reason", "description"))
+	must(t, ns.Save())
+
+	// Closing the receiver saves the namespace without closing it
+	receiver, err := ns.NewReceiver(queue, &azservicebus.ReceiverOptions{SubQueue: azservicebus.SubQueueDeadLetter})
+	must(t, err)
+	received, err := receiver.ReceiveMessages(context.Background(), 1, nil)
+	must(t, err)
+	must(t, receiver.CompleteMessage(context.Background(), received[0], nil))
+	must(t, receiver.Close(context.Background()))
+
+	reopened, err := local.Open(dir)
+	must(t, err)
+	messages, err := reopened.Messages(queue, true)
+	must(t, err)
+	if len(messages) != 1 || messages[0].MessageID != "b" {
+		t.Errorf("DLQ holds %v after reopening, want only b", messages)
+	}
+}
+
+func assertMessage(t *testing.T, got *azservicebus.ReceivedMessage, want *azservicebus.ReceivedMessage) {
+	t.Helper()
+
+	if got.MessageID != want.MessageID || string(got.Body) != string(want.Body) {
+		t.Errorf("message %s with body %q after reopening, want %s with body %q", got.MessageID, got.Body, want.MessageID, want.Body)
+	}
+	if *got.SequenceNumber != *want.SequenceNumber {
+		t.Errorf("message %s has sequence number %d after reopening, want %d", got.MessageID, *got.SequenceNumber, *want.SequenceNumber)
+	}
+	if !reflect.DeepEqual(got.ApplicationProperties, want.ApplicationProperties) {
+		t.Errorf("message %s has properties %v after reopening, want %v", got.MessageID, got.ApplicationProperties, want.ApplicationProperties)
+	}
+	if !reflect.DeepEqual(got.DeadLetterReason, want.DeadLetterReason) || !reflect.DeepEqual(got.DeadLetterErrorDescription, want.DeadLetterErrorDescription) {
+		t.Errorf("message %s has dead-letter reason %v after reopening, want %v", got.MessageID, got.DeadLetterReason, want.DeadLetterReason)
+	}
+}
+
+func highestSequenceNumber(t *testing.T, ns *local.Namespace, entity topics.Entity) int64 {
+	t.Helper()
+
+	var highest int64
+	for _, deadLetter := range []bool{false, true} {
+		messages, err := ns.Messages(entity, deadLetter)
+		must(t, err)
+		for _, msg := range messages {
+			highest = max(highest, *msg.SequenceNumber)
+		}
+	}
+	return highest
+}
+
+func message(id string, tenant string) *azservicebus.Message {
+	return &azservicebus.Message{
+		MessageID:             &id,
+		Body:                  []byte(id),
+		ApplicationProperties: map[string]any{"tenant": tenant},
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,300 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+	"service-bus-hero/topics"
+	"time"
+)
+
+func (n *Namespace) ListTopics(ctx context.Context) ([]string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return sortedKeys(n.topics), nil
+}
+
+func (n *Namespace) ListSubscriptions(ctx context.Context, topicName string) ([]string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	t, ok := n.topics[topicName]
+	if !ok {
+		return nil, fmt.Errorf("topic %s: %w", topicName, topics.ErrNotFound)
+	}
+
+	return sortedKeys(t.subscriptions), nil
+}
+
+func (n *Namespace) ListQueues(ctx context.Context) ([]string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return sortedKeys(n.queues), nil
+}
+
+func (n *Namespace) GetTopicRuntimeProperties(ctx context.Context, topicName string) (*admin.TopicRuntimeProperties, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	t, ok := n.topics[topicName]
+	if !ok {
+		return nil, fmt.Errorf("topic %s: %w", topicName, topics.ErrNotFound)
+	}
+
+	props := n.topicRuntimeProperties(t)
+	return &props, nil
+}
+
+func (n *Namespace) GetSubscriptionRuntimeProperties(ctx context.Context, topicName string, name string) (*admin.SubscriptionRuntimeProperties, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sub, err := n.subscription(topicName, name)
+	if err != nil {
+		return nil, err
+	}
+
+	props := subscriptionRuntimeProperties(sub)
+	return &props, nil
+}
+
+func (n *Namespace) GetQueueRuntimeProperties(ctx context.Context, queue string) (*admin.QueueRuntimeProperties, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	s, ok := n.queues[queue]
+	if !ok {
+		return nil, fmt.Errorf("queue %s: %w", queue, topics.ErrNotFound)
+	}
+
+	props := queueRuntimeProperties(s)
+	return &props, nil
+}
+
+func (n *Namespace) ListTopicsRuntimeProperties(ctx context.Context) ([]admin.TopicRuntimePropertiesItem, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var items []admin.TopicRuntimePropertiesItem
+	for _, name := range sortedKeys(n.topics) {
+		items = append(items, admin.TopicRuntimePropertiesItem{
+			TopicName:              name,
+			TopicRuntimeProperties: n.topicRuntimeProperties(n.topics[name]),
+		})
+	}
+	return items, nil
+}
+
+func (n *Namespace) ListSubscriptionsRuntimeProperties(ctx context.Context, topicName string) ([]admin.SubscriptionRuntimePropertiesItem, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	t, ok := n.topics[topicName]
+	if !ok {
+		return nil, fmt.Errorf("topic %s: %w", topicName, topics.ErrNotFound)
+	}
+
+	var items []admin.SubscriptionRuntimePropertiesItem
+	for _, name := range sortedKeys(t.subscriptions) {
+		items = append(items, admin.SubscriptionRuntimePropertiesItem{
+			TopicName:                     topicName,
+			SubscriptionName:              name,
+			SubscriptionRuntimeProperties: subscriptionRuntimeProperties(t.subscriptions[name]),
+		})
+	}
+	return items, nil
+}
+
+func (n *Namespace) ListQueuesRuntimeProperties(ctx context.Context) ([]admin.QueueRuntimePropertiesItem, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var items []admin.QueueRuntimePropertiesItem
+	for _, name := range sortedKeys(n.queues) {
+		items = append(items, admin.QueueRuntimePropertiesItem{
+			QueueName:              name,
+			QueueRuntimeProperties: queueRuntimeProperties(n.queues[name]),
+		})
+	}
+	return items, nil
+}
+
+func (n *Namespace) GetTopic(ctx context.Context, topicName string) (*admin.TopicProperties, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.topics[topicName]; !ok {
+		return nil, fmt.Errorf("topic %s: %w", topicName, topics.ErrNotFound)
+	}
+
+	props := &admin.TopicProperties{}
+	props.RequiresDuplicateDetection, props.DuplicateDetectionHistoryTimeWindow = n.duplicateDetectionProperties(topicName)
+	return props, nil
+}
+
+func (n *Namespace) GetQueue(ctx context.Context, queue string) (*admin.QueueProperties, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.queues[queue]; !ok {
+		return nil, fmt.Errorf("queue %s: %w", queue, topics.ErrNotFound)
+	}
+
+	props := &admin.QueueProperties{}
+	props.RequiresDuplicateDetection, props.DuplicateDetectionHistoryTimeWindow = n.duplicateDetectionProperties(queue)
+	return props, nil
+}
+
+// duplicateDetectionProperties returns the duplicate detection settings of a queue or topic, with the
+// window as an ISO 8601 duration like the management API reports it.
+func (n *Namespace) duplicateDetectionProperties(queueOrTopic string) (*bool, *string) {
+	window, ok := n.duplicateDetection[queueOrTopic]
+	if !ok {
+		return &ok, nil
+	}
+
+	duration := fmt.Sprintf("PT%dS", int64(window.Seconds()))
+	return &ok, &duration
+}
+
+func (n *Namespace) GetSubscription(ctx context.Context, topicName string, name string) (*admin.SubscriptionProperties, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sub, err := n.subscription(topicName, name)
+	if err != nil {
+		return nil, err
+	}
+
+	props := &admin.SubscriptionProperties{}
+	if sub.forwardTo != "" {
+		forwardTo := sub.forwardTo
+		props.ForwardTo = &forwardTo
+	}
+	return props, nil
+}
+
+func (n *Namespace) ListRules(ctx context.Context, topicName string, name string) ([]admin.RuleProperties, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sub, err := n.subscription(topicName, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]admin.RuleProperties(nil), sub.rules...), nil
+}
+
+func (n *Namespace) GetRule(ctx context.Context, topicName string, name string, ruleName string) (*admin.RuleProperties, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sub, err := n.subscription(topicName, name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range sub.rules {
+		if rule.Name == ruleName {
+			r := rule
+			return &r, nil
+		}
+	}
+	return nil, fmt.Errorf("rule %s/%s/%s: %w", topicName, name, ruleName, topics.ErrNotFound)
+}
+
+func (n *Namespace) CreateRule(ctx context.Context, topicName string, name string, rule admin.RuleProperties) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sub, err := n.subscription(topicName, name)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRule(rule); err != nil {
+		return err
+	}
+
+	for _, existing := range sub.rules {
+		if existing.Name == rule.Name {
+			return fmt.Errorf("rule %s already exists on %s/%s", rule.Name, topicName, name)
+		}
+	}
+
+	sub.rules = append(sub.rules, rule)
+	return nil
+}
+
+func (n *Namespace) topicRuntimeProperties(t *topic) admin.TopicRuntimeProperties {
+	props := admin.TopicRuntimeProperties{
+		CreatedAt:         t.createdAt,
+		UpdatedAt:         t.updatedAt,
+		AccessedAt:        t.accessedAt,
+		SubscriptionCount: int32(len(t.subscriptions)),
+	}
+
+	now := time.Now()
+	for _, sub := range t.subscriptions {
+		counts := sub.store.counts(now)
+		props.ScheduledMessageCount += counts.scheduled
+		props.SizeInBytes += counts.size
+	}
+	return props
+}
+
+func subscriptionRuntimeProperties(sub *subscription) admin.SubscriptionRuntimeProperties {
+	counts := sub.store.counts(time.Now())
+	return admin.SubscriptionRuntimeProperties{
+		TotalMessageCount:      int64(counts.active + counts.scheduled + counts.deadLetter),
+		ActiveMessageCount:     counts.active,
+		DeadLetterMessageCount: counts.deadLetter,
+		CreatedAt:              sub.store.createdAt,
+		UpdatedAt:              sub.store.updatedAt,
+		AccessedAt:             sub.store.accessedAt,
+	}
+}
+
+func queueRuntimeProperties(s *store) admin.QueueRuntimeProperties {
+	counts := s.counts(time.Now())
+	return admin.QueueRuntimeProperties{
+		SizeInBytes:            counts.size,
+		CreatedAt:              s.createdAt,
+		UpdatedAt:              s.updatedAt,
+		AccessedAt:             s.accessedAt,
+		TotalMessageCount:      int64(counts.active + counts.scheduled + counts.deadLetter),
+		ActiveMessageCount:     counts.active,
+		DeadLetterMessageCount: counts.deadLetter,
+		ScheduledMessageCount:  counts.scheduled,
+	}
+}
+
+type storeCounts struct {
+	active     int32
+	scheduled  int32
+	deadLetter int32
+	size       int64
+}
+
+func (s *store) counts(now time.Time) storeCounts {
+	var counts storeCounts
+
+	for _, stored := range s.active {
+		if stored.visibleAt.After(now) {
+			counts.scheduled++
+		} else {
+			counts.active++
+		}
+		counts.size += int64(len(stored.message.Body))
+	}
+
+	for _, stored := range s.deadLetter {
+		counts.deadLetter++
+		counts.size += int64(len(stored.message.Body))
+	}
+
+	return counts
+}
@@ -0,0 +1,541 @@
+// Package memory is an in-memory Service Bus namespace implementing the topics backend interfaces,
+// so commands can be exercised without a namespace:
+//
+//	ns := memory.New("test")
+//	ns.CreateTopic("orders")
+//	ns.CreateSubscription("orders", "billing")
+//	client := topics.NewClientWithBackend(ns.Name(), ns, ns)
+//
+// Topics fan messages out to their subscriptions through correlation, true, false and SQL filters. SQL
+// filters are limited to conditions on application properties joined with AND, such as
+// "tenant = 'acme' AND [sbhero-redeliver-to] IS NULL"; rules with any other expression are refused.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+	"service-bus-hero/topics"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// LockDuration is how long a peek-lock receive holds a message.
+	LockDuration = time.Minute
+	// MaxDeliveryCount is how often a message can be abandoned before it is dead-lettered.
+	MaxDeliveryCount = 10
+	defaultRuleName  = "$Default"
+)
+
+var (
+	_ topics.Admin     = (*Namespace)(nil)
+	_ topics.Messaging = (*Namespace)(nil)
+)
+
+// Namespace holds queues and topics in memory and is safe for concurrent use.
+type Namespace struct {
+	mu             sync.Mutex
+	name           string
+	topics         map[string]*topic
+	queues         map[string]*store
+	sequenceNumber int64
+	lockCounter    uint64
+	// duplicateDetection holds the detection window of queues and topics that require duplicate detection,
+	// and sentMessageIDs when each message ID was last accepted by them.
+	duplicateDetection map[string]time.Duration
+	sentMessageIDs     map[string]map[string]time.Time
+}
+
+type topic struct {
+	name          string
+	createdAt     time.Time
+	updatedAt     time.Time
+	accessedAt    time.Time
+	subscriptions map[string]*subscription
+}
+
+type subscription struct {
+	store     *store
+	forwardTo string
+	rules     []admin.RuleProperties
+}
+
+// store holds the active and dead-lettered messages of a queue or subscription.
+type store struct {
+	createdAt  time.Time
+	updatedAt  time.Time
+	accessedAt time.Time
+	active     []*storedMessage
+	deadLetter []*storedMessage
+}
+
+type storedMessage struct {
+	message     *azservicebus.ReceivedMessage
+	visibleAt   time.Time
+	lockToken   [16]byte
+	lockedUntil time.Time
+}
+
+func New(name string) *Namespace {
+	return &Namespace{
+		name:               topics.NormalizeNamespace(name),
+		topics:             make(map[string]*topic),
+		queues:             make(map[string]*store),
+		duplicateDetection: make(map[string]time.Duration),
+		sentMessageIDs:     make(map[string]map[string]time.Time),
+	}
+}
+
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+func (n *Namespace) CreateQueue(name string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.queues[name]; ok {
+		return fmt.Errorf("queue %s already exists", name)
+	}
+
+	n.queues[name] = newStore()
+	return nil
+}
+
+func (n *Namespace) CreateTopic(name string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.topics[name]; ok {
+		return fmt.Errorf("topic %s already exists", name)
+	}
+
+	now := time.Now()
+	n.topics[name] = &topic{name: name, createdAt: now, updatedAt: now, subscriptions: make(map[string]*subscription)}
+	return nil
+}
+
+// CreateSubscription adds a subscription with a $Default rule that accepts every message.
+func (n *Namespace) CreateSubscription(topicName string, name string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	t, ok := n.topics[topicName]
+	if !ok {
+		return fmt.Errorf("topic %s does not exist", topicName)
+	}
+	if _, ok := t.subscriptions[name]; ok {
+		return fmt.Errorf("subscription %s/%s already exists", topicName, name)
+	}
+
+	t.subscriptions[name] = &subscription{
+		store: newStore(),
+		rules: []admin.RuleProperties{{Name: defaultRuleName, Filter: &admin.TrueFilter{}}},
+	}
+	return nil
+}
+
+// SetForwardTo makes a subscription auto-forward every message it accepts to a queue or topic.
+func (n *Namespace) SetForwardTo(topicName string, name string, forwardTo string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sub, err := n.subscription(topicName, name)
+	if err != nil {
+		return err
+	}
+
+	sub.forwardTo = forwardTo
+	return nil
+}
+
+// SetDuplicateDetection makes a queue or topic drop messages whose ID it accepted less than window ago, as
+// Service Bus does for entities that require duplicate detection. A window of 0 turns detection off.
+func (n *Namespace) SetDuplicateDetection(queueOrTopic string, window time.Duration) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	_, isQueue := n.queues[queueOrTopic]
+	_, isTopic := n.topics[queueOrTopic]
+	if !isQueue && !isTopic {
+		return fmt.Errorf("queue or topic %s: %w", queueOrTopic, topics.ErrNotFound)
+	}
+
+	if window <= 0 {
+		delete(n.duplicateDetection, queueOrTopic)
+		delete(n.sentMessageIDs, queueOrTopic)
+		return nil
+	}
+
+	n.duplicateDetection[queueOrTopic] = window
+	return nil
+}
+
+// ReplaceRules replaces every rule of a subscription, for example to swap $Default for a SQL filter.
+func (n *Namespace) ReplaceRules(topicName string, name string, rules ...admin.RuleProperties) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, rule := range rules {
+		if err := checkRule(rule); err != nil {
+			return err
+		}
+	}
+
+	sub, err := n.subscription(topicName, name)
+	if err != nil {
+		return err
+	}
+
+	sub.rules = append([]admin.RuleProperties(nil), rules...)
+	return nil
+}
+
+// Send delivers messages to a queue or topic as a sender would. Like a batch sent to Service Bus, either
+// every message is delivered or, when one of them cannot be, none of them is. Messages the queue or topic
+// detects as duplicates are dropped without an error; auto-forwarding targets do not detect duplicates.
+func (n *Namespace) Send(queueOrTopic string, messages ...*azservicebus.Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	routes := make([]route, len(messages))
+	for i, msg := range messages {
+		if err := n.resolve(&routes[i], queueOrTopic, msg, 0); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+
+	for i, msg := range messages {
+		if n.duplicate(queueOrTopic, msg, now) {
+			continue
+		}
+
+		for _, t := range routes[i].topics {
+			t.updatedAt = now
+		}
+
+		for _, s := range routes[i].stores {
+			s.active = append(s.active, n.newStoredMessage(msg))
+			s.updatedAt = now
+		}
+	}
+
+	return nil
+}
+
+// AddDeadLetter puts a message straight into the DLQ of a queue or subscription.
+func (n *Namespace) AddDeadLetter(entity topics.Entity, msg *azservicebus.Message, reason string, description string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	s, err := n.store(entity)
+	if err != nil {
+		return err
+	}
+
+	stored := n.newStoredMessage(msg)
+	stored.message.DeadLetterReason = &reason
+	stored.message.DeadLetterErrorDescription = &description
+	source := entity.String()
+	stored.message.DeadLetterSource = &source
+
+	s.deadLetter = append(s.deadLetter, stored)
+	s.updatedAt = time.Now()
+	return nil
+}
+
+// Restore puts previously received messages back into the active or dead-lettered messages of a queue or
+// subscription as they are, keeping their sequence numbers, delivery counts and dead-letter properties.
+// Messages without a sequence number, or with one the entity already holds, are given a new one.
+func (n *Namespace) Restore(entity topics.Entity, deadLetter bool, messages ...*azservicebus.ReceivedMessage) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	s, err := n.store(entity)
+	if err != nil {
+		return err
+	}
+
+	used := make(map[int64]bool)
+	for _, stored := range append(s.queue(false), s.queue(true)...) {
+		used[*stored.message.SequenceNumber] = true
+	}
+
+	now := time.Now()
+	restored := s.queue(deadLetter)
+
+	for _, msg := range messages {
+		received := copyMessage(msg)
+		received.LockToken = [16]byte{}
+		received.LockedUntil = nil
+
+		if received.SequenceNumber == nil || used[*received.SequenceNumber] {
+			n.sequenceNumber++
+			sequenceNumber := n.sequenceNumber
+			received.SequenceNumber = &sequenceNumber
+		}
+		used[*received.SequenceNumber] = true
+		n.sequenceNumber = max(n.sequenceNumber, *received.SequenceNumber)
+
+		if received.EnqueuedTime == nil {
+			received.EnqueuedTime = &now
+		}
+
+		stored := &storedMessage{message: received, visibleAt: now}
+		if received.ScheduledEnqueueTime != nil && received.ScheduledEnqueueTime.After(now) {
+			stored.visibleAt = *received.ScheduledEnqueueTime
+		}
+
+		restored = append(restored, stored)
+	}
+
+	// Peeking pages through messages by sequence number, so they are kept in that order
+	sort.SliceStable(restored, func(i, j int) bool {
+		return *restored[i].message.SequenceNumber < *restored[j].message.SequenceNumber
+	})
+
+	s.setQueue(deadLetter, restored)
+	s.updatedAt = now
+	return nil
+}
+
+// Messages returns copies of the active or dead-lettered messages of a queue or subscription, in
+// sequence number order.
+func (n *Namespace) Messages(entity topics.Entity, deadLetter bool) ([]*azservicebus.ReceivedMessage, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	s, err := n.store(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*azservicebus.ReceivedMessage
+	for _, stored := range s.queue(deadLetter) {
+		messages = append(messages, copyMessage(stored.message))
+	}
+	return messages, nil
+}
+
+func newStore() *store {
+	now := time.Now()
+	return &store{createdAt: now, updatedAt: now}
+}
+
+func (s *store) queue(deadLetter bool) []*storedMessage {
+	if deadLetter {
+		return s.deadLetter
+	}
+	return s.active
+}
+
+func (s *store) setQueue(deadLetter bool, messages []*storedMessage) {
+	if deadLetter {
+		s.deadLetter = messages
+	} else {
+		s.active = messages
+	}
+}
+
+func (n *Namespace) subscription(topicName string, name string) (*subscription, error) {
+	t, ok := n.topics[topicName]
+	if !ok {
+		return nil, fmt.Errorf("topic %s: %w", topicName, topics.ErrNotFound)
+	}
+
+	sub, ok := t.subscriptions[name]
+	if !ok {
+		return nil, fmt.Errorf("subscription %s/%s: %w", topicName, name, topics.ErrNotFound)
+	}
+
+	return sub, nil
+}
+
+func (n *Namespace) store(entity topics.Entity) (*store, error) {
+	if entity.Kind == topics.EntityKindQueue {
+		s, ok := n.queues[entity.Queue]
+		if !ok {
+			return nil, fmt.Errorf("queue %s: %w", entity.Queue, topics.ErrNotFound)
+		}
+		return s, nil
+	}
+
+	sub, err := n.subscription(entity.Topic, entity.Subscription)
+	if err != nil {
+		return nil, err
+	}
+	return sub.store, nil
+}
+
+func (n *Namespace) newStoredMessage(msg *azservicebus.Message) *storedMessage {
+	n.sequenceNumber++
+	sequenceNumber := n.sequenceNumber
+	now := time.Now()
+
+	messageID := fmt.Sprintf("memory-%d", sequenceNumber)
+	if msg.MessageID != nil {
+		messageID = *msg.MessageID
+	}
+
+	received := &azservicebus.ReceivedMessage{
+		ApplicationProperties: copyProperties(msg.ApplicationProperties),
+		Body:                  append([]byte(nil), msg.Body...),
+		ContentType:           msg.ContentType,
+		CorrelationID:         msg.CorrelationID,
+		EnqueuedTime:          &now,
+		MessageID:             messageID,
+		PartitionKey:          msg.PartitionKey,
+		ReplyTo:               msg.ReplyTo,
+		ReplyToSessionID:      msg.ReplyToSessionID,
+		ScheduledEnqueueTime:  msg.ScheduledEnqueueTime,
+		SequenceNumber:        &sequenceNumber,
+		SessionID:             msg.SessionID,
+		Subject:               msg.Subject,
+		TimeToLive:            msg.TimeToLive,
+		To:                    msg.To,
+	}
+
+	stored := &storedMessage{message: received, visibleAt: now}
+
+	if msg.ScheduledEnqueueTime != nil && msg.ScheduledEnqueueTime.After(now) {
+		stored.visibleAt = *msg.ScheduledEnqueueTime
+		received.State = azservicebus.MessageStateScheduled
+	}
+
+	return stored
+}
+
+// duplicate reports whether queueOrTopic accepted a message with the ID of msg within its duplicate
+// detection window, and otherwise records the ID.
+func (n *Namespace) duplicate(queueOrTopic string, msg *azservicebus.Message, now time.Time) bool {
+	window, ok := n.duplicateDetection[queueOrTopic]
+	if !ok || msg.MessageID == nil {
+		return false
+	}
+
+	sent := n.sentMessageIDs[queueOrTopic]
+	if sent == nil {
+		sent = make(map[string]time.Time)
+		n.sentMessageIDs[queueOrTopic] = sent
+	}
+
+	if sentAt, ok := sent[*msg.MessageID]; ok && now.Sub(sentAt) < window {
+		return true
+	}
+
+	sent[*msg.MessageID] = now
+	return false
+}
+
+// route lists the topics a message passes through and the queues and subscriptions that store a copy of it.
+type route struct {
+	topics []*topic
+	stores []*store
+}
+
+// resolve adds where a message sent to queueOrTopic ends up to r without changing anything: the queue
+// itself, or every subscription of the topic whose rules accept it. Auto-forwarding is followed up to a
+// small depth to stop forwarding loops.
+func (n *Namespace) resolve(r *route, queueOrTopic string, msg *azservicebus.Message, depth int) error {
+	if depth > 4 {
+		return fmt.Errorf("auto-forwarding chain through %s is too long", queueOrTopic)
+	}
+
+	if s, ok := n.queues[queueOrTopic]; ok {
+		r.stores = append(r.stores, s)
+		return nil
+	}
+
+	t, ok := n.topics[queueOrTopic]
+	if !ok {
+		return fmt.Errorf("queue or topic %s: %w", queueOrTopic, topics.ErrNotFound)
+	}
+
+	r.topics = append(r.topics, t)
+
+	for _, name := range sortedKeys(t.subscriptions) {
+		sub := t.subscriptions[name]
+		if !accepts(sub.rules, msg) {
+			continue
+		}
+
+		if sub.forwardTo != "" {
+			if err := n.resolve(r, sub.forwardTo, msg, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+
+		r.stores = append(r.stores, sub.store)
+	}
+
+	return nil
+}
+
+// checkRule refuses SQL filters the namespace cannot evaluate, so a rule never silently matches more or
+// less than it would in Service Bus.
+func checkRule(rule admin.RuleProperties) error {
+	if filter, ok := rule.Filter.(*admin.SQLFilter); ok {
+		if _, err := parseSQLFilter(filter.Expression); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func accepts(rules []admin.RuleProperties, msg *azservicebus.Message) bool {
+	for _, rule := range rules {
+		switch filter := rule.Filter.(type) {
+		case *admin.TrueFilter:
+			return true
+		case *admin.CorrelationFilter:
+			if topics.CorrelationFilterMatches(filter, msg) {
+				return true
+			}
+		case *admin.SQLFilter:
+			// Expressions are checked when rules are set, so one that cannot be parsed matches nothing
+			conditions, err := parseSQLFilter(filter.Expression)
+			if err == nil && sqlFilterMatches(conditions, msg) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func copyMessage(msg *azservicebus.ReceivedMessage) *azservicebus.ReceivedMessage {
+	c := *msg
+	c.ApplicationProperties = copyProperties(msg.ApplicationProperties)
+	return &c
+}
+
+func copyProperties(properties map[string]any) map[string]any {
+	if properties == nil {
+		return nil
+	}
+
+	c := make(map[string]any, len(properties))
+	for key, value := range properties {
+		c[key] = value
+	}
+	return c
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Close does nothing; the namespace lives as long as it is referenced.
+func (n *Namespace) Close(ctx context.Context) error {
+	return nil
+}
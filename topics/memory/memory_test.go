@@ -0,0 +1,455 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+	"service-bus-hero/topics"
+	"service-bus-hero/topics/memory"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, ns *memory.Namespace)
+		target   string
+		messages []*azservicebus.Message
+		wantErr  bool
+		want     map[string]int
+	}{
+		{
+			name:     "queue",
+			target:   "invoices",
+			messages: []*azservicebus.Message{message("a"), message("b")},
+			want:     map[string]int{"invoices": 2, "orders/audit": 0, "orders/billing": 0},
+		},
+		{
+			name:     "topic fans out to every subscription",
+			target:   "orders",
+			messages: []*azservicebus.Message{message("a")},
+			want:     map[string]int{"invoices": 0, "orders/audit": 1, "orders/billing": 1},
+		},
+		{
+			name: "false filter",
+			setup: func(t *testing.T, ns *memory.Namespace) {
+				must(t, ns.ReplaceRules("orders", "audit", admin.RuleProperties{Name: "none", Filter: &admin.FalseFilter{}}))
+			},
+			target:   "orders",
+			messages: []*azservicebus.Message{message("a")},
+			want:     map[string]int{"orders/audit": 0, "orders/billing": 1},
+		},
+		{
+			name: "correlation filter",
+			setup: func(t *testing.T, ns *memory.Namespace) {
+				must(t, ns.ReplaceRules("orders", "audit", admin.RuleProperties{Name: "acme", Filter: &admin.CorrelationFilter{
+					ApplicationProperties: map[string]any{"tenant": "acme"},
+				}}))
+			},
+			target:   "orders",
+			messages: []*azservicebus.Message{withProperty(message("a"), "tenant", "acme"), withProperty(message("b"), "tenant", "other")},
+			want:     map[string]int{"orders/audit": 1, "orders/billing": 2},
+		},
+		{
+			name: "SQL filters",
+			setup: func(t *testing.T, ns *memory.Namespace) {
+				must(t, ns.ReplaceRules("orders", "audit", admin.RuleProperties{Name: "unset", Filter: &admin.SQLFilter{Expression: "[tenant] IS NULL"}}))
+				must(t, ns.ReplaceRules("orders", "billing", admin.RuleProperties{Name: "set", Filter: &admin.SQLFilter{Expression: "[tenant] is not null"}}))
+			},
+			target:   "orders",
+			messages: []*azservicebus.Message{withProperty(message("a"), "tenant", "acme"), message("b"), message("c")},
+			want:     map[string]int{"orders/audit": 2, "orders/billing": 1},
+		},
+		{
+			name: "auto-forwarding",
+			setup: func(t *testing.T, ns *memory.Namespace) {
+				must(t, ns.SetForwardTo("orders", "audit", "invoices"))
+			},
+			target:   "orders",
+			messages: []*azservicebus.Message{message("a")},
+			want:     map[string]int{"invoices": 1, "orders/audit": 0, "orders/billing": 1},
+		},
+		{
+			name: "batch with an undeliverable message delivers nothing",
+			setup: func(t *testing.T, ns *memory.Namespace) {
+				must(t, ns.CreateSubscription("orders", "archive"))
+				must(t, ns.ReplaceRules("orders", "archive", admin.RuleProperties{Name: "archived", Filter: &admin.SQLFilter{Expression: "[archived] IS NOT NULL"}}))
+				must(t, ns.SetForwardTo("orders", "archive", "missing"))
+			},
+			target:   "orders",
+			messages: []*azservicebus.Message{message("a"), withProperty(message("b"), "archived", true)},
+			wantErr:  true,
+			want:     map[string]int{"orders/audit": 0, "orders/billing": 0, "orders/archive": 0},
+		},
+		{
+			name: "forwarding loop",
+			setup: func(t *testing.T, ns *memory.Namespace) {
+				must(t, ns.SetForwardTo("orders", "audit", "orders"))
+			},
+			target:   "orders",
+			messages: []*azservicebus.Message{message("a")},
+			wantErr:  true,
+			want:     map[string]int{"orders/billing": 0},
+		},
+		{
+			name: "duplicate detection",
+			setup: func(t *testing.T, ns *memory.Namespace) {
+				must(t, ns.SetDuplicateDetection("invoices", time.Minute))
+			},
+			target:   "invoices",
+			messages: []*azservicebus.Message{message("a"), message("a"), message("b"), {Body: []byte("no ID")}, {Body: []byte("no ID")}},
+			want:     map[string]int{"invoices": 4},
+		},
+		{
+			name:     "missing target",
+			target:   "missing",
+			messages: []*azservicebus.Message{message("a")},
+			wantErr:  true,
+			want:     map[string]int{"invoices": 0, "orders/audit": 0, "orders/billing": 0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := newNamespace(t)
+			if test.setup != nil {
+				test.setup(t, ns)
+			}
+
+			err := ns.Send(test.target, test.messages...)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Send() error = %v, want error %v", err, test.wantErr)
+			}
+
+			for entity, want := range test.want {
+				assertCount(t, ns, parseEntity(entity), false, want)
+			}
+		})
+	}
+}
+
+func TestSQLFilters(t *testing.T) {
+	guarded := "tenant = 'acme' AND [sbhero-redeliver-to] IS NULL"
+
+	tests := []struct {
+		name       string
+		expression string
+		properties map[string]any
+		wantErr    bool
+		want       bool
+	}{
+		{"IS NULL", "[tenant] IS NULL", nil, false, true},
+		{"IS NOT NULL", "[tenant] is not null", nil, false, false},
+		{"guarded compound filter", guarded, map[string]any{"tenant": "acme"}, false, true},
+		{"guarded compound filter with redelivered message", guarded, map[string]any{"tenant": "acme", "sbhero-redeliver-to": "billing"}, false, false},
+		{"guarded compound filter with other tenant", guarded, map[string]any{"tenant": "other"}, false, false},
+		{"group", "(tenant = 'acme' AND priority = 1) AND [sbhero-redeliver-to] IS NULL", map[string]any{"tenant": "acme", "priority": int32(1)}, false, true},
+		{"not equal", "user.tenant <> 'acme'", map[string]any{"tenant": "other"}, false, true},
+		{"not equal without the property", "tenant != 'acme'", nil, false, false},
+		{"boolean", "flag = TRUE", map[string]any{"flag": true}, false, true},
+		{"escaped quote", "note = 'it''s'", map[string]any{"note": "it's"}, false, true},
+		{"OR", "tenant = 'acme' OR [sbhero-redeliver-to] IS NULL", nil, true, false},
+		{"NOT", "NOT [sbhero-redeliver-to] IS NULL", nil, true, false},
+		{"LIKE", "tenant LIKE 'a%'", nil, true, false},
+		{"system property", "sys.Label = 'invoice'", nil, true, false},
+		{"constant", "1=1", nil, true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := newNamespace(t)
+
+			err := ns.ReplaceRules("orders", "audit", admin.RuleProperties{Name: "filter", Filter: &admin.SQLFilter{Expression: test.expression}})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ReplaceRules() error = %v, want error %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			msg := message("a")
+			msg.ApplicationProperties = test.properties
+			must(t, ns.Send("orders", msg))
+
+			want := 0
+			if test.want {
+				want = 1
+			}
+			assertCount(t, ns, topics.NewSubscriptionEntity("orders", "audit"), false, want)
+		})
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	queue := topics.NewQueueEntity("invoices")
+
+	tests := []struct {
+		name       string
+		settle     func(ctx context.Context, receiver topics.Receiver, msg *azservicebus.ReceivedMessage) error
+		wantActive int
+		wantDLQ    int
+	}{
+		{
+			name: "complete",
+			settle: func(ctx context.Context, receiver topics.Receiver, msg *azservicebus.ReceivedMessage) error {
+				return receiver.CompleteMessage(ctx, msg, nil)
+			},
+			wantActive: 1,
+		},
+		{
+			name: "abandon",
+			settle: func(ctx context.Context, receiver topics.Receiver, msg *azservicebus.ReceivedMessage) error {
+				return receiver.AbandonMessage(ctx, msg, nil)
+			},
+			wantActive: 2,
+		},
+		{
+			name: "dead-letter",
+			settle: func(ctx context.Context, receiver topics.Receiver, msg *azservicebus.ReceivedMessage) error {
+				reason := "reason"
+				return receiver.DeadLetterMessage(ctx, msg, &azservicebus.DeadLetterOptions{Reason: &reason})
+			},
+			wantActive: 1,
+			wantDLQ:    1,
+		},
+		{
+			name: "renew lock",
+			settle: func(ctx context.Context, receiver topics.Receiver, msg *azservicebus.ReceivedMessage) error {
+				return receiver.RenewMessageLock(ctx, msg, nil)
+			},
+			wantActive: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			ns := newNamespace(t)
+			must(t, ns.Send("invoices", message("a"), message("b")))
+
+			receiver, err := ns.NewReceiver(queue, nil)
+			must(t, err)
+
+			received, err := receiver.ReceiveMessages(ctx, 1, nil)
+			must(t, err)
+			if len(received) != 1 || received[0].MessageID != "a" || received[0].DeliveryCount != 1 {
+				t.Fatalf("ReceiveMessages() = %v, want message a delivered once", received)
+			}
+
+			// The locked message is not received again while its lock is held
+			next, err := receiver.ReceiveMessages(ctx, 10, nil)
+			must(t, err)
+			if len(next) != 1 || next[0].MessageID != "b" {
+				t.Fatalf("ReceiveMessages() = %v, want only message b", next)
+			}
+
+			must(t, test.settle(ctx, receiver, received[0]))
+
+			assertCount(t, ns, queue, false, test.wantActive)
+			assertCount(t, ns, queue, true, test.wantDLQ)
+		})
+	}
+}
+
+func TestReceiverLockLost(t *testing.T) {
+	ctx := context.Background()
+	ns := newNamespace(t)
+	must(t, ns.Send("invoices", message("a")))
+
+	receiver, err := ns.NewReceiver(topics.NewQueueEntity("invoices"), nil)
+	must(t, err)
+
+	received, err := receiver.ReceiveMessages(ctx, 1, nil)
+	must(t, err)
+	must(t, receiver.AbandonMessage(ctx, received[0], nil))
+
+	if err := receiver.CompleteMessage(ctx, received[0], nil); !errors.Is(err, memory.ErrLockLost) {
+		t.Errorf("CompleteMessage() after abandoning error = %v, want %v", err, memory.ErrLockLost)
+	}
+}
+
+func TestReceiveAndDelete(t *testing.T) {
+	ctx := context.Background()
+	ns := newNamespace(t)
+	queue := topics.NewQueueEntity("invoices")
+	must(t, ns.AddDeadLetter(queue, message("a"), "reason", "description"))
+
+	receiver, err := ns.NewReceiver(queue, &azservicebus.ReceiverOptions{
+		ReceiveMode: azservicebus.ReceiveModeReceiveAndDelete,
+		SubQueue:    azservicebus.SubQueueDeadLetter,
+	})
+	must(t, err)
+
+	received, err := receiver.ReceiveMessages(ctx, 10, nil)
+	must(t, err)
+	if len(received) != 1 || *received[0].DeadLetterReason != "reason" {
+		t.Fatalf("ReceiveMessages() = %v, want the dead-lettered message", received)
+	}
+
+	assertCount(t, ns, queue, true, 0)
+
+	if err := receiver.CompleteMessage(ctx, received[0], nil); err == nil {
+		t.Error("CompleteMessage() in ReceiveAndDelete mode succeeded, want an error")
+	}
+}
+
+func TestMaxDeliveryCount(t *testing.T) {
+	ctx := context.Background()
+	ns := newNamespace(t)
+	subscription := topics.NewSubscriptionEntity("orders", "billing")
+	must(t, ns.Send("orders", message("a")))
+
+	receiver, err := ns.NewReceiver(subscription, nil)
+	must(t, err)
+
+	for i := 0; i < memory.MaxDeliveryCount; i++ {
+		received, err := receiver.ReceiveMessages(ctx, 1, nil)
+		must(t, err)
+		if len(received) != 1 {
+			t.Fatalf("delivery %d received %d messages, want 1", i+1, len(received))
+		}
+		must(t, receiver.AbandonMessage(ctx, received[0], nil))
+	}
+
+	assertCount(t, ns, subscription, false, 0)
+
+	deadLettered, err := ns.Messages(subscription, true)
+	must(t, err)
+	if len(deadLettered) != 1 || *deadLettered[0].DeadLetterReason != "MaxDeliveryCountExceeded" {
+		t.Fatalf("DLQ holds %v, want the message dead-lettered for MaxDeliveryCountExceeded", deadLettered)
+	}
+	if *deadLettered[0].DeadLetterSource != subscription.String() {
+		t.Errorf("DeadLetterSource = %s, want %s", *deadLettered[0].DeadLetterSource, subscription)
+	}
+}
+
+func TestRuntimeProperties(t *testing.T) {
+	ctx := context.Background()
+	ns := newNamespace(t)
+	queue := topics.NewQueueEntity("invoices")
+	subscription := topics.NewSubscriptionEntity("orders", "billing")
+
+	scheduled := message("later")
+	enqueueAt := time.Now().Add(time.Hour)
+	scheduled.ScheduledEnqueueTime = &enqueueAt
+
+	must(t, ns.Send("invoices", message("a"), message("b"), scheduled))
+	must(t, ns.AddDeadLetter(queue, message("c"), "reason", "description"))
+	must(t, ns.Send("orders", message("d")))
+	must(t, ns.AddDeadLetter(subscription, message("e"), "reason", "description"))
+	must(t, ns.AddDeadLetter(subscription, message("f"), "reason", "description"))
+
+	queueProps, err := ns.GetQueueRuntimeProperties(ctx, "invoices")
+	must(t, err)
+	subscriptionProps, err := ns.GetSubscriptionRuntimeProperties(ctx, "orders", "billing")
+	must(t, err)
+	topicProps, err := ns.GetTopicRuntimeProperties(ctx, "orders")
+	must(t, err)
+
+	tests := []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"queue active", int64(queueProps.ActiveMessageCount), 2},
+		{"queue scheduled", int64(queueProps.ScheduledMessageCount), 1},
+		{"queue dead-letter", int64(queueProps.DeadLetterMessageCount), 1},
+		{"queue total", queueProps.TotalMessageCount, 4},
+		{"subscription active", int64(subscriptionProps.ActiveMessageCount), 1},
+		{"subscription dead-letter", int64(subscriptionProps.DeadLetterMessageCount), 2},
+		{"subscription total", subscriptionProps.TotalMessageCount, 3},
+		{"topic subscriptions", int64(topicProps.SubscriptionCount), 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.got != test.want {
+				t.Errorf("got %d, want %d", test.got, test.want)
+			}
+		})
+	}
+}
+
+func TestGettersReturnErrNotFound(t *testing.T) {
+	ctx := context.Background()
+	ns := newNamespace(t)
+
+	tests := []struct {
+		name string
+		get  func() error
+	}{
+		{"topic", func() error { _, err := ns.GetTopic(ctx, "missing"); return err }},
+		{"queue", func() error { _, err := ns.GetQueue(ctx, "missing"); return err }},
+		{"subscription", func() error { _, err := ns.GetSubscription(ctx, "orders", "missing"); return err }},
+		{"subscription of missing topic", func() error { _, err := ns.GetSubscription(ctx, "missing", "billing"); return err }},
+		{"rule", func() error { _, err := ns.GetRule(ctx, "orders", "billing", "missing"); return err }},
+		{"topic runtime properties", func() error { _, err := ns.GetTopicRuntimeProperties(ctx, "missing"); return err }},
+		{"queue runtime properties", func() error { _, err := ns.GetQueueRuntimeProperties(ctx, "missing"); return err }},
+		{"subscription runtime properties", func() error {
+			_, err := ns.GetSubscriptionRuntimeProperties(ctx, "orders", "missing")
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.get(); !errors.Is(err, topics.ErrNotFound) {
+				t.Errorf("error = %v, want %v", err, topics.ErrNotFound)
+			}
+		})
+	}
+}
+
+// newNamespace returns a namespace with the queue invoices and the topic orders with the subscriptions
+// audit and billing.
+func newNamespace(t *testing.T) *memory.Namespace {
+	t.Helper()
+
+	ns := memory.New("test")
+	must(t, ns.CreateQueue("invoices"))
+	must(t, ns.CreateTopic("orders"))
+	must(t, ns.CreateSubscription("orders", "audit"))
+	must(t, ns.CreateSubscription("orders", "billing"))
+	return ns
+}
+
+// parseEntity turns "queue" or "topic/subscription" into an entity.
+func parseEntity(name string) topics.Entity {
+	if topic, subscription, ok := strings.Cut(name, "/"); ok {
+		return topics.NewSubscriptionEntity(topic, subscription)
+	}
+	return topics.NewQueueEntity(name)
+}
+
+func message(id string) *azservicebus.Message {
+	return &azservicebus.Message{MessageID: &id, Body: []byte(id)}
+}
+
+func withProperty(msg *azservicebus.Message, name string, value any) *azservicebus.Message {
+	if msg.ApplicationProperties == nil {
+		msg.ApplicationProperties = make(map[string]any)
+	}
+	msg.ApplicationProperties[name] = value
+	return msg
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertCount(t *testing.T, ns *memory.Namespace, entity topics.Entity, deadLetter bool, want int) {
+	t.Helper()
+
+	messages, err := ns.Messages(entity, deadLetter)
+	must(t, err)
+
+	if len(messages) != want {
+		t.Errorf("%s has %d messages (dead-letter %v), want %d", entity, len(messages), deadLetter, want)
+	}
+}
@@ -0,0 +1,285 @@
+package memory
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"service-bus-hero/topics"
+	"time"
+)
+
+// MaxBatchSize is the number of body bytes a message batch accepts before AddMessage reports
+// azservicebus.ErrMessageTooLarge, matching the 256 KB limit of the standard tier.
+const MaxBatchSize = 256 * 1024
+
+// ErrLockLost is returned when a message is settled or renewed with a lock that expired or was never held.
+var ErrLockLost = errors.New("message lock lost")
+
+func (n *Namespace) NewReceiver(entity topics.Entity, options *azservicebus.ReceiverOptions) (topics.Receiver, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, err := n.store(entity); err != nil {
+		return nil, err
+	}
+
+	r := &receiver{namespace: n, entity: entity}
+	if options != nil {
+		r.deadLetter = options.SubQueue == azservicebus.SubQueueDeadLetter
+		r.receiveAndDelete = options.ReceiveMode == azservicebus.ReceiveModeReceiveAndDelete
+	}
+	return r, nil
+}
+
+func (n *Namespace) NewSender(queueOrTopic string) (topics.Sender, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	_, isQueue := n.queues[queueOrTopic]
+	_, isTopic := n.topics[queueOrTopic]
+	if !isQueue && !isTopic {
+		return nil, fmt.Errorf("queue or topic %s: %w", queueOrTopic, topics.ErrNotFound)
+	}
+
+	return &sender{namespace: n, target: queueOrTopic}, nil
+}
+
+type receiver struct {
+	namespace        *Namespace
+	entity           topics.Entity
+	deadLetter       bool
+	receiveAndDelete bool
+}
+
+// ReceiveMessages returns up to maxMessages visible, unlocked messages in sequence number order. Unlike
+// the real receiver it returns right away with no messages instead of waiting for new ones to arrive.
+func (r *receiver) ReceiveMessages(ctx context.Context, maxMessages int, options *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	n := r.namespace
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	s, err := n.store(r.entity)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s.accessedAt = now
+
+	var received []*azservicebus.ReceivedMessage
+	var remaining []*storedMessage
+
+	for _, stored := range s.queue(r.deadLetter) {
+		available := len(received) < maxMessages && !stored.visibleAt.After(now) && !stored.lockedUntil.After(now)
+		if !available {
+			remaining = append(remaining, stored)
+			continue
+		}
+
+		stored.message.DeliveryCount++
+
+		if r.receiveAndDelete {
+			received = append(received, copyMessage(stored.message))
+			continue
+		}
+
+		n.lockCounter++
+		binary.BigEndian.PutUint64(stored.lockToken[8:], n.lockCounter)
+		stored.lockedUntil = now.Add(LockDuration)
+
+		msg := copyMessage(stored.message)
+		msg.LockToken = stored.lockToken
+		lockedUntil := stored.lockedUntil
+		msg.LockedUntil = &lockedUntil
+
+		received = append(received, msg)
+		remaining = append(remaining, stored)
+	}
+
+	s.setQueue(r.deadLetter, remaining)
+
+	return received, nil
+}
+
+// PeekMessages returns up to maxMessageCount messages starting at FromSequenceNumber without locking them.
+func (r *receiver) PeekMessages(ctx context.Context, maxMessageCount int, options *azservicebus.PeekMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	n := r.namespace
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	s, err := n.store(r.entity)
+	if err != nil {
+		return nil, err
+	}
+
+	var from int64
+	if options != nil && options.FromSequenceNumber != nil {
+		from = *options.FromSequenceNumber
+	}
+
+	var peeked []*azservicebus.ReceivedMessage
+	for _, stored := range s.queue(r.deadLetter) {
+		if len(peeked) == maxMessageCount {
+			break
+		}
+		if *stored.message.SequenceNumber >= from {
+			peeked = append(peeked, copyMessage(stored.message))
+		}
+	}
+
+	return peeked, nil
+}
+
+func (r *receiver) CompleteMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.CompleteMessageOptions) error {
+	return r.settle(message, func(s *store, index int) {
+		messages := s.queue(r.deadLetter)
+		s.setQueue(r.deadLetter, append(messages[:index:index], messages[index+1:]...))
+	})
+}
+
+// AbandonMessage releases the lock. Active messages that reach MaxDeliveryCount are dead-lettered.
+func (r *receiver) AbandonMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.AbandonMessageOptions) error {
+	return r.settle(message, func(s *store, index int) {
+		stored := s.queue(r.deadLetter)[index]
+		stored.lockedUntil = time.Time{}
+
+		if !r.deadLetter && stored.message.DeliveryCount >= MaxDeliveryCount {
+			r.moveToDeadLetter(s, index, "MaxDeliveryCountExceeded", fmt.Sprintf("Message could not be consumed after %d delivery attempts.", MaxDeliveryCount))
+		}
+	})
+}
+
+func (r *receiver) DeadLetterMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeadLetterOptions) error {
+	if r.deadLetter {
+		return errors.New("messages in a dead-letter queue cannot be dead-lettered")
+	}
+
+	reason, description := "", ""
+	if options != nil {
+		if options.Reason != nil {
+			reason = *options.Reason
+		}
+		if options.ErrorDescription != nil {
+			description = *options.ErrorDescription
+		}
+	}
+
+	return r.settle(message, func(s *store, index int) {
+		r.moveToDeadLetter(s, index, reason, description)
+	})
+}
+
+func (r *receiver) RenewMessageLock(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.RenewMessageLockOptions) error {
+	return r.settle(message, func(s *store, index int) {
+		stored := s.queue(r.deadLetter)[index]
+		stored.lockedUntil = time.Now().Add(LockDuration)
+
+		lockedUntil := stored.lockedUntil
+		message.LockedUntil = &lockedUntil
+	})
+}
+
+func (r *receiver) Close(ctx context.Context) error {
+	return nil
+}
+
+// settle finds the stored message holding the lock of message and calls fn while the namespace is locked.
+func (r *receiver) settle(message *azservicebus.ReceivedMessage, fn func(s *store, index int)) error {
+	if r.receiveAndDelete {
+		return errors.New("messages received in ReceiveAndDelete mode cannot be settled")
+	}
+
+	n := r.namespace
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	s, err := n.store(r.entity)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for index, stored := range s.queue(r.deadLetter) {
+		if stored.lockToken == message.LockToken && stored.lockedUntil.After(now) {
+			fn(s, index)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("message %s: %w", message.MessageID, ErrLockLost)
+}
+
+func (r *receiver) moveToDeadLetter(s *store, index int, reason string, description string) {
+	stored := s.active[index]
+	s.active = append(s.active[:index:index], s.active[index+1:]...)
+
+	stored.lockedUntil = time.Time{}
+	stored.message.DeadLetterReason = &reason
+	stored.message.DeadLetterErrorDescription = &description
+	source := r.entity.String()
+	stored.message.DeadLetterSource = &source
+
+	s.deadLetter = append(s.deadLetter, stored)
+	s.updatedAt = time.Now()
+}
+
+type sender struct {
+	namespace *Namespace
+	target    string
+}
+
+func (s *sender) NewMessageBatch(ctx context.Context, options *azservicebus.MessageBatchOptions) (topics.MessageBatch, error) {
+	maxBytes := uint64(MaxBatchSize)
+	if options != nil && options.MaxBytes > 0 {
+		maxBytes = options.MaxBytes
+	}
+	return &messageBatch{maxBytes: maxBytes}, nil
+}
+
+func (s *sender) SendMessageBatch(ctx context.Context, batch topics.MessageBatch, options *azservicebus.SendMessageBatchOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	memoryBatch, ok := batch.(*messageBatch)
+	if !ok {
+		return errors.New("message batch was not created by this sender")
+	}
+
+	return s.namespace.Send(s.target, memoryBatch.messages...)
+}
+
+func (s *sender) Close(ctx context.Context) error {
+	return nil
+}
+
+type messageBatch struct {
+	maxBytes uint64
+	size     uint64
+	messages []*azservicebus.Message
+}
+
+func (b *messageBatch) AddMessage(message *azservicebus.Message, options *azservicebus.AddMessageOptions) error {
+	size := uint64(len(message.Body))
+	if b.size+size > b.maxBytes {
+		return azservicebus.ErrMessageTooLarge
+	}
+
+	b.size += size
+	b.messages = append(b.messages, message)
+	return nil
+}
+
+func (b *messageBatch) NumMessages() int32 {
+	return int32(len(b.messages))
+}
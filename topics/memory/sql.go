@@ -0,0 +1,201 @@
+package memory
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"service-bus-hero/topics"
+	"strconv"
+	"strings"
+)
+
+// sqlCondition is one condition of a SQL filter on an application property.
+type sqlCondition struct {
+	property string
+	// operator is "IS NULL", "IS NOT NULL", "=" or "<>"
+	operator string
+	value    any
+}
+
+// parseSQLFilter parses the subset of the SQL filter syntax the namespace evaluates: conditions joined
+// with AND, each of them "<property> IS [NOT] NULL" or "<property> = <literal>" with =, <> or !=, where
+// the property is an application property, optionally bracketed or prefixed with user., and the literal
+// a string, a number, TRUE or FALSE. Any other expression is rejected rather than guessed at.
+func parseSQLFilter(expression string) ([]sqlCondition, error) {
+	terms, ok := topics.SplitSQLConjunction(expression)
+	if !ok {
+		return nil, fmt.Errorf("SQL filter %q is not supported by the memory namespace, only conditions joined with AND are", expression)
+	}
+
+	conditions := make([]sqlCondition, 0, len(terms))
+	for _, term := range terms {
+		term = strings.TrimSpace(term)
+
+		// A condition group in parentheses passed the split as one term, and its parentheses are balanced
+		if strings.HasPrefix(term, "(") && strings.HasSuffix(term, ")") {
+			if _, ok := topics.SplitSQLConjunction(term[1 : len(term)-1]); ok {
+				group, err := parseSQLFilter(term[1 : len(term)-1])
+				if err != nil {
+					return nil, err
+				}
+				conditions = append(conditions, group...)
+				continue
+			}
+		}
+
+		condition, err := parseSQLCondition(term)
+		if err != nil {
+			return nil, fmt.Errorf("SQL filter %q is not supported by the memory namespace: %w", expression, err)
+		}
+		conditions = append(conditions, condition)
+	}
+
+	return conditions, nil
+}
+
+func parseSQLCondition(term string) (sqlCondition, error) {
+	name, rest, err := parseSQLProperty(term)
+	if err != nil {
+		return sqlCondition{}, err
+	}
+
+	switch strings.ToUpper(strings.Join(strings.Fields(rest), " ")) {
+	case "IS NULL":
+		return sqlCondition{property: name, operator: "IS NULL"}, nil
+	case "IS NOT NULL":
+		return sqlCondition{property: name, operator: "IS NOT NULL"}, nil
+	}
+
+	rest = strings.TrimSpace(rest)
+	for _, operator := range []string{"<>", "!=", "="} {
+		literal, ok := strings.CutPrefix(rest, operator)
+		if !ok {
+			continue
+		}
+
+		value, err := parseSQLLiteral(literal)
+		if err != nil {
+			return sqlCondition{}, err
+		}
+
+		if operator == "!=" {
+			operator = "<>"
+		}
+		return sqlCondition{property: name, operator: operator, value: value}, nil
+	}
+
+	return sqlCondition{}, fmt.Errorf("condition %q is not supported", term)
+}
+
+// parseSQLProperty reads the application property a condition starts with and returns it with the rest
+// of the condition.
+func parseSQLProperty(term string) (string, string, error) {
+	term = strings.TrimPrefix(term, "user.")
+
+	if strings.HasPrefix(term, "[") {
+		end := strings.IndexByte(term, ']')
+		if end < 2 {
+			return "", "", fmt.Errorf("condition %q does not start with a property", term)
+		}
+		return term[1:end], term[end+1:], nil
+	}
+
+	end := 0
+	for end < len(term) && (term[end] == '_' || term[end] == '-' || isAlphanumeric(term[end])) {
+		end++
+	}
+	if end == 0 || term[0] >= '0' && term[0] <= '9' || end < len(term) && term[end] == '.' {
+		return "", "", fmt.Errorf("condition %q does not start with an application property", term)
+	}
+
+	return term[:end], term[end:], nil
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func parseSQLLiteral(s string) (any, error) {
+	s = strings.TrimSpace(s)
+
+	if len(s) >= 2 && strings.HasPrefix(s, "'") && strings.HasSuffix(s, "'") {
+		inner := s[1 : len(s)-1]
+		if strings.Contains(strings.ReplaceAll(inner, "''", ""), "'") {
+			return nil, fmt.Errorf("literal %s is not a single string", s)
+		}
+		return strings.ReplaceAll(inner, "''", "'"), nil
+	}
+
+	switch strings.ToUpper(s) {
+	case "TRUE":
+		return true, nil
+	case "FALSE":
+		return false, nil
+	}
+
+	if number, err := strconv.ParseFloat(s, 64); err == nil {
+		return number, nil
+	}
+
+	return nil, fmt.Errorf("literal %q is not supported", s)
+}
+
+// sqlFilterMatches reports whether every condition holds for msg. As in Service Bus, a comparison with a
+// property the message does not have is never true.
+func sqlFilterMatches(conditions []sqlCondition, msg *azservicebus.Message) bool {
+	for _, condition := range conditions {
+		value, present := msg.ApplicationProperties[condition.property]
+
+		switch condition.operator {
+		case "IS NULL":
+			if present {
+				return false
+			}
+		case "IS NOT NULL":
+			if !present {
+				return false
+			}
+		case "=":
+			if !present || !sqlEqual(value, condition.value) {
+				return false
+			}
+		case "<>":
+			if !present || sqlEqual(value, condition.value) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func sqlEqual(value any, literal any) bool {
+	if number, ok := literal.(float64); ok {
+		switch v := value.(type) {
+		case int:
+			return float64(v) == number
+		case int8:
+			return float64(v) == number
+		case int16:
+			return float64(v) == number
+		case int32:
+			return float64(v) == number
+		case int64:
+			return float64(v) == number
+		case uint8:
+			return float64(v) == number
+		case uint16:
+			return float64(v) == number
+		case uint32:
+			return float64(v) == number
+		case uint64:
+			return float64(v) == number
+		case float32:
+			return float64(v) == number
+		case float64:
+			return v == number
+		}
+		return false
+	}
+
+	return value == literal
+}
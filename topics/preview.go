@@ -0,0 +1,103 @@
+package topics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// DLQPreview describes what resending or clearing the DLQ of an entity would do. It is gathered with
+// PeekMessages, so nothing is locked, settled or sent.
+type DLQPreview struct {
+	Entity Entity
+	// Target is where resent messages would be sent to; it is empty for a clear.
+	Target string
+	// Notes list changes made to the namespace besides moving messages, such as rules that would be created.
+	Notes []string
+	// Messages is how many messages are in the DLQ, and Skipped how many of them a resend would leave there.
+	Messages int
+	Skipped  int
+	// Reasons counts the messages per dead-letter reason.
+	Reasons map[string]int
+	// Sample holds the first messages of the DLQ.
+	Sample []*azservicebus.ReceivedMessage
+}
+
+// PreviewResend reports where ResendDLQMessages would send the DLQ messages of entity to and which of them it
+// would skip, without creating rules or locking messages.
+func (c *Client) PreviewResend(ctx context.Context, entity Entity, options *ResendOptions, sampleSize int) (*DLQPreview, error) {
+	plan, err := c.planRedelivery(ctx, entity, options)
+	if err != nil {
+		return nil, fmt.Errorf("could not plan redelivery: %w", err)
+	}
+
+	preview := &DLQPreview{Entity: entity, Target: plan.target}
+
+	if plan.target != entity.SendTarget() {
+		preview.Notes = append(preview.Notes, fmt.Sprintf("%s forwards to %s, messages would be sent there directly", entity, plan.target))
+	}
+	if plan.createRule {
+		preview.Notes = append(preview.Notes, fmt.Sprintf("rule %s would be created on %s", redeliveryRuleName, entity))
+	}
+	if plan.routeTo != "" {
+		preview.Notes = append(preview.Notes, fmt.Sprintf("messages would be stamped with %s=%s", RedeliveryProperty, plan.routeTo))
+	}
+
+	err = c.peekDLQ(ctx, preview, sampleSize, func(msg *azservicebus.ReceivedMessage) bool {
+		return len(plan.conflicts(plan.prepare(msg))) > 0
+	})
+	if err != nil {
+		return preview, err
+	}
+
+	return preview, nil
+}
+
+// PreviewClear reports which messages ClearDLQMessages would delete from the DLQ of entity.
+func (c *Client) PreviewClear(ctx context.Context, entity Entity, sampleSize int) (*DLQPreview, error) {
+	preview := &DLQPreview{Entity: entity}
+
+	if err := c.peekDLQ(ctx, preview, sampleSize, nil); err != nil {
+		return preview, err
+	}
+
+	return preview, nil
+}
+
+// peekDLQ counts the DLQ messages of the previewed entity and keeps the first sampleSize of them. skip
+// reports messages that would be left in the DLQ.
+func (c *Client) peekDLQ(ctx context.Context, preview *DLQPreview, sampleSize int, skip func(*azservicebus.ReceivedMessage) bool) error {
+	preview.Reasons = make(map[string]int)
+
+	messageChan, errChan := c.BrowseMessages(ctx, preview.Entity, BrowseOptions{DeadLetter: true})
+
+	for msg := range messageChan {
+		preview.Messages++
+
+		reason := ""
+		if msg.DeadLetterReason != nil {
+			reason = *msg.DeadLetterReason
+		}
+		preview.Reasons[reason]++
+
+		if skip != nil && skip(msg) {
+			preview.Skipped++
+		}
+
+		if len(preview.Sample) < sampleSize {
+			preview.Sample = append(preview.Sample, msg)
+		}
+	}
+
+	for err := range errChan {
+		if errors.Is(err, ErrInterrupted) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("could not peek DLQ messages: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package topics
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+)
+
+func (c *Client) FetchQueues(ctx context.Context) ([]string, error) {
+	queues, err := c.admin.ListQueues(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch queues: %w", err)
+	}
+
+	return queues, nil
+}
+
+func (c *Client) FetchQueueStats(ctx context.Context, queue string) (*admin.QueueRuntimeProperties, error) {
+	queueProps, err := c.admin.GetQueueRuntimeProperties(ctx, queue)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch queue runtime properties: %w", err)
+	}
+
+	return queueProps, nil
+}
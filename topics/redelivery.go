@@ -0,0 +1,402 @@
+package topics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+	"net/url"
+	"os"
+	"service-bus-hero/io"
+	"strings"
+)
+
+type RedeliveryMode int
+
+const (
+	// RedeliverToTopic republishes DLQ messages to the topic, so every subscription whose rules
+	// match receives a copy.
+	RedeliverToTopic RedeliveryMode = iota
+	// RedeliverToSubscription routes DLQ messages back to the subscription that dead-lettered them only.
+	RedeliverToSubscription
+)
+
+func (m RedeliveryMode) String() string {
+	if m == RedeliverToSubscription {
+		return "subscription"
+	}
+	return "topic"
+}
+
+func ParseRedeliveryMode(mode string) (RedeliveryMode, error) {
+	switch strings.ToLower(mode) {
+	case "topic":
+		return RedeliverToTopic, nil
+	case "subscription":
+		return RedeliverToSubscription, nil
+	default:
+		return 0, fmt.Errorf("unknown redelivery mode %q", mode)
+	}
+}
+
+const (
+	// RedeliveryProperty is stamped on messages redelivered to a single subscription and holds its name.
+	RedeliveryProperty = "sbhero-redeliver-to"
+	redeliveryRuleName = "sbhero-redelivery"
+)
+
+type ResendOptions struct {
+	Redelivery RedeliveryMode
+	Overrides  *io.MessageOverrides
+	// Archive stores every batch of DLQ messages before it is resent.
+	Archive ArchiveFunc
+	// Resent is called with the DLQ messages of every batch that have been sent.
+	Resent func(messages []*azservicebus.ReceivedMessage)
+}
+
+// redeliveryPlan describes where resent messages are sent to and which sibling subscriptions
+// have to be checked for duplicates.
+type redeliveryPlan struct {
+	target    string
+	routeTo   string
+	siblings  []subscriptionRules
+	overrides *io.MessageOverrides
+	archive   ArchiveFunc
+	resent    func(messages []*azservicebus.ReceivedMessage)
+	// createRule is set when the redelivery rule does not exist on the subscription yet.
+	createRule bool
+}
+
+type subscriptionRules struct {
+	subscription string
+	rules        []admin.RuleProperties
+}
+
+// planRedelivery works out where the DLQ messages of entity are resent to without changing anything, so
+// it is shared by resends and their previews.
+func (c *Client) planRedelivery(ctx context.Context, entity Entity, options *ResendOptions) (*redeliveryPlan, error) {
+	plan, err := c.planTarget(ctx, entity, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.checkDuplicateDetection(ctx, plan); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// planTarget picks the queue or topic resent messages are sent to and, for redelivery to a subscription,
+// the rules that keep its siblings from receiving them.
+func (c *Client) planTarget(ctx context.Context, entity Entity, options *ResendOptions) (*redeliveryPlan, error) {
+	plan := &redeliveryPlan{target: entity.SendTarget()}
+
+	if options != nil {
+		plan.overrides = options.Overrides
+		plan.archive = options.Archive
+		plan.resent = options.Resent
+	}
+
+	if entity.Kind == EntityKindQueue || options == nil || options.Redelivery == RedeliverToTopic {
+		return plan, nil
+	}
+
+	subscription, err := c.admin.GetSubscription(ctx, entity.Topic, entity.Subscription)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("subscription %s does not exist", entity)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch subscription: %w", err)
+	}
+
+	// A subscription that auto-forwards is the only source of its forwarding target,
+	// so sending there directly reaches nobody else.
+	if subscription.ForwardTo != nil && *subscription.ForwardTo != "" {
+		plan.target = forwardTarget(*subscription.ForwardTo)
+		return plan, nil
+	}
+
+	_, err = c.admin.GetRule(ctx, entity.Topic, entity.Subscription, redeliveryRuleName)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("could not fetch redelivery rule: %w", err)
+	}
+
+	plan.createRule = err != nil
+	plan.routeTo = entity.Subscription
+
+	subscriptions, err := c.FetchTopicSubscriptions(ctx, entity.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	var unguarded []string
+
+	for _, sibling := range subscriptions {
+		if sibling == entity.Subscription {
+			continue
+		}
+
+		rules, err := c.fetchRules(ctx, entity.Topic, sibling)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rule := range rules {
+			if ruleAlwaysMatches(rule) {
+				unguarded = append(unguarded, fmt.Sprintf("%s (rule %s)", sibling, rule.Name))
+			}
+		}
+
+		plan.siblings = append(plan.siblings, subscriptionRules{subscription: sibling, rules: rules})
+	}
+
+	if len(unguarded) > 0 {
+		return nil, fmt.Errorf(
+			"redelivered messages would also reach %s; exclude them with a SQL filter such as \"%s\", or \"<filter> AND %s\", or resend to the whole topic",
+			strings.Join(unguarded, ", "),
+			redeliveryGuard,
+			redeliveryGuard,
+		)
+	}
+
+	return plan, nil
+}
+
+// checkDuplicateDetection refuses to resend messages with their original IDs to a queue or topic that
+// requires duplicate detection. It silently drops a message whose ID it has seen within its detection
+// window, and the DLQ copy would be completed all the same.
+func (c *Client) checkDuplicateDetection(ctx context.Context, plan *redeliveryPlan) error {
+	if plan.overrides.Overrides("messageID") {
+		return nil
+	}
+
+	required, err := c.requiresDuplicateDetection(ctx, plan.target)
+	if err != nil {
+		return err
+	}
+
+	if required {
+		return fmt.Errorf("%s requires duplicate detection and would drop resent messages whose IDs it has already seen while they are removed from the DLQ; strip messageID to resend them with new IDs", plan.target)
+	}
+
+	return nil
+}
+
+func (c *Client) requiresDuplicateDetection(ctx context.Context, queueOrTopic string) (bool, error) {
+	queue, err := c.admin.GetQueue(ctx, queueOrTopic)
+	if err == nil {
+		return queue.RequiresDuplicateDetection != nil && *queue.RequiresDuplicateDetection, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return false, fmt.Errorf("could not fetch queue %s: %w", queueOrTopic, err)
+	}
+
+	topic, err := c.admin.GetTopic(ctx, queueOrTopic)
+	if err != nil {
+		return false, fmt.Errorf("could not fetch topic %s: %w", queueOrTopic, err)
+	}
+
+	return topic.RequiresDuplicateDetection != nil && *topic.RequiresDuplicateDetection, nil
+}
+
+// createRedeliveryRule adds a rule to the subscription that accepts every message addressed to it
+// through RedeliveryProperty.
+func (c *Client) createRedeliveryRule(ctx context.Context, entity Entity) error {
+	err := c.admin.CreateRule(ctx, entity.Topic, entity.Subscription, admin.RuleProperties{
+		Name: redeliveryRuleName,
+		Filter: &admin.CorrelationFilter{
+			ApplicationProperties: map[string]any{RedeliveryProperty: entity.Subscription},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not create redelivery rule: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Created rule %s on %s\n", redeliveryRuleName, entity)
+
+	return nil
+}
+
+func (c *Client) fetchRules(ctx context.Context, topic string, subscription string) ([]admin.RuleProperties, error) {
+	rules, err := c.admin.ListRules(ctx, topic, subscription)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// ruleAlwaysMatches reports rules that cannot be shown to reject redelivered messages. SQL filters
+// are only trusted to exclude them when they are guarded by redeliveryGuard.
+func ruleAlwaysMatches(rule admin.RuleProperties) bool {
+	switch filter := rule.Filter.(type) {
+	case *admin.FalseFilter, *admin.CorrelationFilter:
+		return false
+	case *admin.SQLFilter:
+		return !sqlFilterGuarded(filter.Expression)
+	default:
+		return true
+	}
+}
+
+// redeliveryGuard is the SQL condition that keeps a subscription from accepting redelivered messages.
+var redeliveryGuard = "[" + RedeliveryProperty + "] IS NULL"
+
+// sqlFilterGuarded reports whether redeliveryGuard is one of the conditions the whole expression is an AND
+// of. Expressions with an OR outside of parentheses are never guarded, since the OR could bypass it.
+func sqlFilterGuarded(expression string) bool {
+	conditions, ok := SplitSQLConjunction(expression)
+	if !ok {
+		return false
+	}
+
+	for _, condition := range conditions {
+		if strings.EqualFold(strings.Join(strings.Fields(condition), " "), redeliveryGuard) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SplitSQLConjunction splits a SQL filter expression at every AND outside of parentheses, strings and
+// bracketed names. It fails for expressions with an OR at that level and for unbalanced expressions.
+func SplitSQLConjunction(expression string) ([]string, bool) {
+	var conditions []string
+	depth := 0
+	start := 0
+
+	for i := 0; i < len(expression); i++ {
+		switch expression[i] {
+		case '\'':
+			// An escaped quote ('') is read as two adjacent strings
+			end := strings.IndexByte(expression[i+1:], '\'')
+			if end < 0 {
+				return nil, false
+			}
+			i += end + 1
+		case '[':
+			end := strings.IndexByte(expression[i+1:], ']')
+			if end < 0 {
+				return nil, false
+			}
+			i += end + 1
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, false
+			}
+		default:
+			if depth > 0 {
+				continue
+			}
+			if keywordAt(expression, i, "OR") {
+				return nil, false
+			}
+			if keywordAt(expression, i, "AND") {
+				conditions = append(conditions, expression[start:i])
+				start = i + len("AND")
+				i = start - 1
+			}
+		}
+	}
+
+	if depth != 0 {
+		return nil, false
+	}
+
+	return append(conditions, expression[start:]), true
+}
+
+// keywordAt reports whether keyword starts at index i of s as a whole word, ignoring case.
+func keywordAt(s string, i int, keyword string) bool {
+	end := i + len(keyword)
+	if end > len(s) || !strings.EqualFold(s[i:end], keyword) {
+		return false
+	}
+
+	return (i == 0 || !isNameChar(s[i-1])) && (end == len(s) || !isNameChar(s[end]))
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// prepare copies every settable property of msg into a new message and applies the overrides.
+func (p *redeliveryPlan) prepare(msg *azservicebus.ReceivedMessage) *azservicebus.Message {
+	newMsg := msg.Message()
+	p.overrides.Apply(newMsg)
+
+	if p.routeTo != "" {
+		properties := make(map[string]any, len(newMsg.ApplicationProperties)+1)
+		for key, value := range newMsg.ApplicationProperties {
+			properties[key] = value
+		}
+		properties[RedeliveryProperty] = p.routeTo
+		newMsg.ApplicationProperties = properties
+	}
+
+	return newMsg
+}
+
+// conflicts returns the sibling subscriptions whose correlation rules would also accept msg.
+func (p *redeliveryPlan) conflicts(msg *azservicebus.Message) []string {
+	var subscriptions []string
+
+	for _, sibling := range p.siblings {
+		for _, rule := range sibling.rules {
+			filter, ok := rule.Filter.(*admin.CorrelationFilter)
+			if ok && CorrelationFilterMatches(filter, msg) {
+				subscriptions = append(subscriptions, sibling.subscription)
+				break
+			}
+		}
+	}
+
+	return subscriptions
+}
+
+// CorrelationFilterMatches reports whether a message sent as msg would be accepted by filter.
+func CorrelationFilterMatches(filter *admin.CorrelationFilter, msg *azservicebus.Message) bool {
+	fields := []struct {
+		want *string
+		have *string
+	}{
+		{filter.ContentType, msg.ContentType},
+		{filter.CorrelationID, msg.CorrelationID},
+		{filter.MessageID, msg.MessageID},
+		{filter.ReplyTo, msg.ReplyTo},
+		{filter.ReplyToSessionID, msg.ReplyToSessionID},
+		{filter.SessionID, msg.SessionID},
+		{filter.Subject, msg.Subject},
+		{filter.To, msg.To},
+	}
+
+	for _, field := range fields {
+		if field.want != nil && (field.have == nil || *field.have != *field.want) {
+			return false
+		}
+	}
+
+	for key, want := range filter.ApplicationProperties {
+		have, ok := msg.ApplicationProperties[key]
+		if !ok || fmt.Sprint(have) != fmt.Sprint(want) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// forwardTarget turns the absolute URI the service reports for ForwardTo into an entity name.
+func forwardTarget(forwardTo string) string {
+	u, err := url.Parse(forwardTo)
+	if err != nil || u.Host == "" {
+		return forwardTo
+	}
+	return strings.TrimPrefix(u.Path, "/")
+}
@@ -0,0 +1,43 @@
+package topics
+
+import (
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+	"testing"
+)
+
+func TestRuleAlwaysMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter admin.RuleFilter
+		want   bool
+	}{
+		{"true filter", &admin.TrueFilter{}, true},
+		{"false filter", &admin.FalseFilter{}, false},
+		{"correlation filter", &admin.CorrelationFilter{}, false},
+		{"guard", &admin.SQLFilter{Expression: "[sbhero-redeliver-to] IS NULL"}, false},
+		{"guard with other spacing and case", &admin.SQLFilter{Expression: "  [sbhero-redeliver-to]   is null "}, false},
+		{"guard after condition", &admin.SQLFilter{Expression: "tenant = 'acme' AND [sbhero-redeliver-to] IS NULL"}, false},
+		{"guard before condition", &admin.SQLFilter{Expression: "[sbhero-redeliver-to] IS NULL and (tenant = 'a' OR tenant = 'b')"}, false},
+		{"guard with OR", &admin.SQLFilter{Expression: "[sbhero-redeliver-to] IS NULL OR 1=1"}, true},
+		{"guard inside OR", &admin.SQLFilter{Expression: "tenant = 'acme' AND [sbhero-redeliver-to] IS NULL OR 1=1"}, true},
+		{"other comparison", &admin.SQLFilter{Expression: "[sbhero-redeliver-to] = 'other' OR 1=1"}, true},
+		{"IS NOT NULL", &admin.SQLFilter{Expression: "[sbhero-redeliver-to] IS NOT NULL"}, true},
+		{"negated guard", &admin.SQLFilter{Expression: "NOT [sbhero-redeliver-to] IS NULL"}, true},
+		{"guard in parentheses with OR", &admin.SQLFilter{Expression: "([sbhero-redeliver-to] IS NULL OR 1=1)"}, true},
+		{"guard in string", &admin.SQLFilter{Expression: "note = 'x AND [sbhero-redeliver-to] IS NULL'"}, true},
+		{"OR in string", &admin.SQLFilter{Expression: "note = 'a OR b' AND [sbhero-redeliver-to] IS NULL"}, false},
+		{"OR in name", &admin.SQLFilter{Expression: "[a OR b] = 1 AND [sbhero-redeliver-to] IS NULL"}, false},
+		{"word containing OR", &admin.SQLFilter{Expression: "ORDER = 1 AND [sbhero-redeliver-to] IS NULL"}, false},
+		{"unbalanced", &admin.SQLFilter{Expression: "(tenant = 'acme' AND [sbhero-redeliver-to] IS NULL"}, true},
+		{"unterminated string", &admin.SQLFilter{Expression: "[sbhero-redeliver-to] IS NULL AND note = 'x"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ruleAlwaysMatches(admin.RuleProperties{Name: "rule", Filter: test.filter})
+			if got != test.want {
+				t.Errorf("ruleAlwaysMatches() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package topics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lockRenewInterval is how often locks held on DLQ messages are checked while they are being resent,
+// lockRenewMargin is how close to expiry a lock has to be before it is renewed and lockRenewConcurrency
+// is how many locks are renewed at once, so a large held set is renewed within the margin.
+const (
+	lockRenewInterval    = 5 * time.Second
+	lockRenewMargin      = 20 * time.Second
+	lockRenewConcurrency = 16
+)
+
+type ResendResult struct {
+	Sent      int
+	Completed int
+	Abandoned int
+	Skipped   int
+}
+
+func (r ResendResult) String() string {
+	return fmt.Sprintf("sent %d, completed %d, abandoned %d, skipped %d", r.Sent, r.Completed, r.Abandoned, r.Skipped)
+}
+
+// ResendDLQMessages sends the dead-lettered messages of an entity back to its queue or topic.
+// Messages are received in peek-lock mode, archived when the options ask for it, and only completed
+// once the batch containing them has been sent; messages that could not be sent are abandoned and stay
+// in the DLQ. When ctx is cancelled the batch in flight is still sent and settled before ErrInterrupted
+// is returned.
+func (c *Client) ResendDLQMessages(ctx context.Context, entity Entity, options *ResendOptions) (ResendResult, error) {
+	var result ResendResult
+
+	plan, err := c.planRedelivery(ctx, entity, options)
+	if err != nil {
+		return result, fmt.Errorf("could not plan redelivery: %w", err)
+	}
+
+	if plan.target != entity.SendTarget() {
+		fmt.Fprintf(os.Stderr, "%s forwards to %s, redelivering there directly\n", entity, plan.target)
+	}
+
+	receiver, err := c.newReceiver(
+		entity,
+		&azservicebus.ReceiverOptions{
+			SubQueue:    azservicebus.SubQueueDeadLetter,
+			ReceiveMode: azservicebus.ReceiveModePeekLock,
+		},
+	)
+	if err != nil {
+		return result, fmt.Errorf("could not create receiver for DLQ: %w", err)
+	}
+	defer receiver.Close(context.WithoutCancel(ctx))
+
+	sender, err := c.messaging.NewSender(plan.target)
+	if err != nil {
+		return result, fmt.Errorf("could not create sender for %s: %w", plan.target, err)
+	}
+	defer sender.Close(context.WithoutCancel(ctx))
+
+	dlqMessageCount, err := c.GetDLQMessageCount(ctx, entity)
+	if err != nil {
+		return result, fmt.Errorf("could not fetch DLQ message count: %w", err)
+	}
+
+	if dlqMessageCount == 0 {
+		return result, nil
+	}
+
+	if plan.createRule {
+		if err := c.createRedeliveryRule(ctx, entity); err != nil {
+			return result, err
+		}
+	}
+
+	// Sending and settling a received batch is finished even after an interruption
+	batchCtx := context.WithoutCancel(ctx)
+
+	// Skipped messages stay locked until the end so they are not received again. Their locks are
+	// renewed, and should one still be lost, sequence numbers are tracked to recognise them.
+	skippedMessages := holdMessages(batchCtx, receiver)
+	skippedSequenceNumbers := make(map[int64]bool)
+
+	defer skippedMessages.release()
+
+	processedCount := 0
+	maxBatchSize := 25
+
+	for processedCount < dlqMessageCount {
+		batchMessages, err := receiveBatch(ctx, receiver, maxBatchSize)
+		if err != nil {
+			return result, fmt.Errorf("could not receive messages from DLQ: %w", err)
+		}
+
+		if len(batchMessages) == 0 {
+			break
+		}
+
+		var receivedMessages []*azservicebus.ReceivedMessage
+		var newMessages []*azservicebus.Message
+		newCount := 0
+
+		for _, msg := range batchMessages {
+			if msg.SequenceNumber != nil && skippedSequenceNumbers[*msg.SequenceNumber] {
+				skippedMessages.add(msg)
+				continue
+			}
+
+			newCount++
+
+			newMsg := plan.prepare(msg)
+
+			if conflicts := plan.conflicts(newMsg); len(conflicts) > 0 {
+				fmt.Fprintf(os.Stderr, "Skipping message %s, it would also be delivered to %s\n", msg.MessageID, strings.Join(conflicts, ", "))
+				if msg.SequenceNumber != nil {
+					skippedSequenceNumbers[*msg.SequenceNumber] = true
+				}
+				skippedMessages.add(msg)
+				result.Skipped++
+				continue
+			}
+
+			receivedMessages = append(receivedMessages, msg)
+			newMessages = append(newMessages, newMsg)
+		}
+
+		if newCount == 0 {
+			// Only skipped messages whose locks were lost came back, the rest of the DLQ comes after them
+			continue
+		}
+
+		processedCount += newCount
+
+		if plan.archive != nil && len(receivedMessages) > 0 {
+			if err := plan.archive(receivedMessages); err != nil {
+				for _, msg := range receivedMessages {
+					if err := receiver.AbandonMessage(batchCtx, msg, nil); err == nil {
+						result.Abandoned++
+					}
+				}
+
+				return result, fmt.Errorf("could not archive messages, they were left in the DLQ: %w", err)
+			}
+		}
+
+		stopRenewing := renewMessageLocks(batchCtx, receiver, receivedMessages)
+		sent, sendErr := sendMessages(batchCtx, sender, newMessages)
+		stopRenewing()
+
+		result.Sent += sent
+
+		if plan.resent != nil && sent > 0 {
+			plan.resent(receivedMessages[:sent])
+		}
+
+		for _, msg := range receivedMessages[:sent] {
+			if err := receiver.CompleteMessage(batchCtx, msg, nil); err != nil {
+				// The message has been resent already, so it is left locked rather than abandoned;
+				// it becomes visible in the DLQ again once the lock expires.
+				fmt.Fprintf(os.Stderr, "Could not complete resent message %s: %v\n", msg.MessageID, err)
+				continue
+			}
+
+			result.Completed++
+		}
+
+		if sendErr != nil {
+			for _, msg := range receivedMessages[sent:] {
+				if err := receiver.AbandonMessage(batchCtx, msg, nil); err != nil {
+					fmt.Fprintf(os.Stderr, "Could not abandon message %s: %v\n", msg.MessageID, err)
+					continue
+				}
+
+				result.Abandoned++
+			}
+
+			return result, fmt.Errorf("could not send messages: %w", sendErr)
+		}
+	}
+
+	if processedCount < dlqMessageCount {
+		printShortReceive(processedCount, dlqMessageCount)
+	}
+
+	return result, nil
+}
+
+// sendMessages sends the messages in as few batches as possible and returns how many of them,
+// counted from the start of the slice, have been sent successfully.
+func sendMessages(ctx context.Context, sender Sender, messages []*azservicebus.Message) (int, error) {
+	sent := 0
+
+	batch, err := sender.NewMessageBatch(ctx, nil)
+	if err != nil {
+		return sent, fmt.Errorf("could not create message batch: %w", err)
+	}
+
+	for _, newMsg := range messages {
+		err := batch.AddMessage(newMsg, nil)
+		if errors.Is(err, azservicebus.ErrMessageTooLarge) && batch.NumMessages() > 0 {
+			// The batch is full, send it and start a new one
+			if err := sender.SendMessageBatch(ctx, batch, nil); err != nil {
+				return sent, fmt.Errorf("could not send message batch: %w", err)
+			}
+			sent += int(batch.NumMessages())
+
+			batch, err = sender.NewMessageBatch(ctx, nil)
+			if err != nil {
+				return sent, fmt.Errorf("could not create message batch: %w", err)
+			}
+			err = batch.AddMessage(newMsg, nil)
+		}
+		if err != nil {
+			return sent, fmt.Errorf("could not add message to batch: %w", err)
+		}
+	}
+
+	if batch.NumMessages() > 0 {
+		if err := sender.SendMessageBatch(ctx, batch, nil); err != nil {
+			return sent, fmt.Errorf("could not send message batch: %w", err)
+		}
+		sent += int(batch.NumMessages())
+	}
+
+	return sent, nil
+}
+
+// renewMessageLocks keeps the locks on messages alive until the returned function is called.
+func renewMessageLocks(ctx context.Context, receiver Receiver, messages []*azservicebus.ReceivedMessage) func() {
+	return renewLocks(ctx, receiver, func() []*azservicebus.ReceivedMessage { return messages })
+}
+
+// heldMessages keeps a growing set of messages locked, so they are not received again, until they are
+// released.
+type heldMessages struct {
+	ctx          context.Context
+	receiver     Receiver
+	mu           sync.Mutex
+	messages     []*azservicebus.ReceivedMessage
+	stopRenewing func()
+}
+
+// holdMessages starts renewing the locks of the messages added to the returned set. ctx is used for
+// renewing and abandoning, so it should outlive an interruption.
+func holdMessages(ctx context.Context, receiver Receiver) *heldMessages {
+	h := &heldMessages{ctx: ctx, receiver: receiver}
+	h.stopRenewing = renewLocks(ctx, receiver, h.snapshot)
+	return h
+}
+
+func (h *heldMessages) add(messages ...*azservicebus.ReceivedMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.messages = append(h.messages, messages...)
+}
+
+func (h *heldMessages) snapshot() []*azservicebus.ReceivedMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.messages
+}
+
+// release stops renewing the locks and abandons every held message, so they become available again.
+func (h *heldMessages) release() {
+	h.stopRenewing()
+
+	for _, msg := range h.snapshot() {
+		_ = h.receiver.AbandonMessage(h.ctx, msg, nil)
+	}
+}
+
+// renewLocks renews the locks on the messages messages returns at every check until the returned function
+// is called.
+func renewLocks(ctx context.Context, receiver Receiver, messages func() []*azservicebus.ReceivedMessage) func() {
+	ctx, cancel := context.WithCancel(ctx)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(lockRenewInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				var renewals sync.WaitGroup
+				slots := make(chan struct{}, lockRenewConcurrency)
+
+				for _, msg := range messages() {
+					if msg.LockedUntil != nil && time.Until(*msg.LockedUntil) > lockRenewMargin {
+						continue
+					}
+
+					slots <- struct{}{}
+					renewals.Add(1)
+
+					go func(msg *azservicebus.ReceivedMessage) {
+						defer renewals.Done()
+						defer func() { <-slots }()
+
+						if err := receiver.RenewMessageLock(ctx, msg, nil); err != nil && ctx.Err() == nil {
+							fmt.Fprintf(os.Stderr, "Could not renew lock on message %s: %v\n", msg.MessageID, err)
+						}
+					}(msg)
+				}
+
+				renewals.Wait()
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
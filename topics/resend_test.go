@@ -0,0 +1,282 @@
+package topics_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+	"service-bus-hero/io"
+	"service-bus-hero/topics"
+	"service-bus-hero/topics/memory"
+	"testing"
+	"time"
+)
+
+func TestResendDLQMessages(t *testing.T) {
+	billing := topics.NewSubscriptionEntity("orders", "billing")
+	audit := topics.NewSubscriptionEntity("orders", "audit")
+	guard := admin.RuleProperties{Name: "guard", Filter: &admin.SQLFilter{Expression: "[" + topics.RedeliveryProperty + "] IS NULL"}}
+
+	tests := []struct {
+		name        string
+		setup       func(t *testing.T, ns *memory.Namespace)
+		redelivery  topics.RedeliveryMode
+		archiveErr  error
+		wantErr     bool
+		wantResult  topics.ResendResult
+		wantResent  int
+		wantBilling int
+		wantAudit   int
+		wantDLQ     int
+		wantRule    bool
+	}{
+		{
+			name:        "to the topic",
+			redelivery:  topics.RedeliverToTopic,
+			wantResult:  topics.ResendResult{Sent: 30, Completed: 30},
+			wantResent:  30,
+			wantBilling: 30,
+			wantAudit:   30,
+		},
+		{
+			name: "to the subscription",
+			setup: func(t *testing.T, ns *memory.Namespace) {
+				must(t, ns.ReplaceRules("orders", "audit", guard))
+			},
+			redelivery:  topics.RedeliverToSubscription,
+			wantResult:  topics.ResendResult{Sent: 30, Completed: 30},
+			wantResent:  30,
+			wantBilling: 30,
+			wantRule:    true,
+		},
+		{
+			name: "to the subscription past a compound guard",
+			setup: func(t *testing.T, ns *memory.Namespace) {
+				must(t, ns.ReplaceRules("orders", "audit", admin.RuleProperties{Name: "acme", Filter: &admin.SQLFilter{
+					Expression: "tenant = 'acme' AND [" + topics.RedeliveryProperty + "] IS NULL",
+				}}))
+			},
+			redelivery:  topics.RedeliverToSubscription,
+			wantResult:  topics.ResendResult{Sent: 30, Completed: 30},
+			wantResent:  30,
+			wantBilling: 30,
+			wantRule:    true,
+		},
+		{
+			name:       "to the subscription with an unguarded sibling",
+			redelivery: topics.RedeliverToSubscription,
+			wantErr:    true,
+			wantDLQ:    30,
+		},
+		{
+			name: "to the subscription skipping messages a sibling accepts",
+			setup: func(t *testing.T, ns *memory.Namespace) {
+				must(t, ns.ReplaceRules("orders", "audit", guard, admin.RuleProperties{Name: "acme", Filter: &admin.CorrelationFilter{
+					ApplicationProperties: map[string]any{"tenant": "acme"},
+				}}))
+			},
+			redelivery:  topics.RedeliverToSubscription,
+			wantResult:  topics.ResendResult{Sent: 20, Completed: 20, Skipped: 10},
+			wantResent:  20,
+			wantBilling: 20,
+			wantDLQ:     10,
+			wantRule:    true,
+		},
+		{
+			name:       "archive failure",
+			redelivery: topics.RedeliverToTopic,
+			archiveErr: errors.New("disk full"),
+			wantErr:    true,
+			wantResult: topics.ResendResult{Abandoned: 25},
+			wantDLQ:    30,
+		},
+		{
+			name: "send failure",
+			setup: func(t *testing.T, ns *memory.Namespace) {
+				// acme messages are forwarded to a queue that does not exist, so their batch cannot be sent
+				must(t, ns.CreateSubscription("orders", "acme"))
+				must(t, ns.ReplaceRules("orders", "acme", admin.RuleProperties{Name: "acme", Filter: &admin.CorrelationFilter{
+					ApplicationProperties: map[string]any{"tenant": "acme"},
+				}}))
+				must(t, ns.SetForwardTo("orders", "acme", "missing"))
+			},
+			redelivery: topics.RedeliverToTopic,
+			wantErr:    true,
+			wantResult: topics.ResendResult{Abandoned: 25},
+			wantDLQ:    30,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := memory.New("test")
+			must(t, ns.CreateTopic("orders"))
+			must(t, ns.CreateSubscription("orders", "audit"))
+			must(t, ns.CreateSubscription("orders", "billing"))
+			if test.setup != nil {
+				test.setup(t, ns)
+			}
+
+			// Every third message belongs to the tenant the acme rule of audit accepts
+			for i := 0; i < 30; i++ {
+				msg := message(fmt.Sprintf("m%d", i))
+				if i%3 == 0 {
+					msg.ApplicationProperties = map[string]any{"tenant": "acme"}
+				}
+				must(t, ns.AddDeadLetter(billing, msg, "reason", "description"))
+			}
+
+			archived, resent := 0, 0
+			options := &topics.ResendOptions{
+				Redelivery: test.redelivery,
+				Archive: func(messages []*azservicebus.ReceivedMessage) error {
+					archived += len(messages)
+					return test.archiveErr
+				},
+				Resent: func(messages []*azservicebus.ReceivedMessage) {
+					resent += len(messages)
+				},
+			}
+
+			client := topics.NewClientWithBackend(ns.Name(), ns, ns)
+			result, err := client.ResendDLQMessages(context.Background(), billing, options)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ResendDLQMessages() error = %v, want error %v", err, test.wantErr)
+			}
+
+			if result != test.wantResult {
+				t.Errorf("ResendDLQMessages() = %v, want %v", result, test.wantResult)
+			}
+			if resent != test.wantResent {
+				t.Errorf("Resent was called with %d messages, want %d", resent, test.wantResent)
+			}
+			if !test.wantErr && archived != test.wantResent {
+				t.Errorf("Archive was called with %d messages, want %d", archived, test.wantResent)
+			}
+
+			assertCount(t, ns, billing, false, test.wantBilling)
+			assertCount(t, ns, audit, false, test.wantAudit)
+			assertCount(t, ns, billing, true, test.wantDLQ)
+
+			// Messages left in the DLQ are unlocked again and can be received right away
+			receiver, err := ns.NewReceiver(billing, &azservicebus.ReceiverOptions{SubQueue: azservicebus.SubQueueDeadLetter})
+			must(t, err)
+			received, err := receiver.ReceiveMessages(context.Background(), 100, nil)
+			must(t, err)
+			if len(received) != test.wantDLQ {
+				t.Errorf("received %d messages from the DLQ after resending, want %d", len(received), test.wantDLQ)
+			}
+
+			_, err = ns.GetRule(context.Background(), "orders", "billing", "sbhero-redelivery")
+			if hasRule := err == nil; hasRule != test.wantRule {
+				t.Errorf("redelivery rule exists = %v, want %v", hasRule, test.wantRule)
+			}
+		})
+	}
+}
+
+func TestResendDLQMessagesHoldsSkipped(t *testing.T) {
+	ns := memory.New("test")
+	billing := topics.NewSubscriptionEntity("orders", "billing")
+	must(t, ns.CreateTopic("orders"))
+	must(t, ns.CreateSubscription("orders", "audit"))
+	must(t, ns.CreateSubscription("orders", "billing"))
+	must(t, ns.ReplaceRules("orders", "audit",
+		admin.RuleProperties{Name: "guard", Filter: &admin.SQLFilter{Expression: "[" + topics.RedeliveryProperty + "] IS NULL"}},
+		admin.RuleProperties{Name: "acme", Filter: &admin.CorrelationFilter{ApplicationProperties: map[string]any{"tenant": "acme"}}},
+	))
+
+	// The skipped acme messages are spread over several receive batches
+	for i := 0; i < 60; i++ {
+		msg := message(fmt.Sprintf("m%d", i))
+		if i%3 == 0 {
+			msg.ApplicationProperties = map[string]any{"tenant": "acme"}
+		}
+		must(t, ns.AddDeadLetter(billing, msg, "reason", "description"))
+	}
+
+	client := topics.NewClientWithBackend(ns.Name(), ns, ns)
+	result, err := client.ResendDLQMessages(context.Background(), billing, &topics.ResendOptions{Redelivery: topics.RedeliverToSubscription})
+	must(t, err)
+
+	want := topics.ResendResult{Sent: 40, Completed: 40, Skipped: 20}
+	if result != want {
+		t.Errorf("ResendDLQMessages() = %v, want %v", result, want)
+	}
+
+	// Skipped messages stayed locked until the end, so each of them was received only once
+	skipped, err := ns.Messages(billing, true)
+	must(t, err)
+	if len(skipped) != 20 {
+		t.Fatalf("DLQ has %d messages, want the 20 skipped ones", len(skipped))
+	}
+	for _, msg := range skipped {
+		if msg.ApplicationProperties["tenant"] != "acme" {
+			t.Errorf("message %s was skipped, but audit does not accept it", msg.MessageID)
+		}
+		if msg.DeliveryCount != 1 {
+			t.Errorf("skipped message %s was delivered %d times, want 1", msg.MessageID, msg.DeliveryCount)
+		}
+	}
+}
+
+func TestResendDLQMessagesDuplicateDetection(t *testing.T) {
+	tests := []struct {
+		name       string
+		strip      []string
+		wantErr    bool
+		wantActive int
+		wantDLQ    int
+	}{
+		{"original message IDs are refused", nil, true, 1, 1},
+		{"stripped message IDs are resent", []string{"messageID"}, false, 2, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := memory.New("test")
+			queue := topics.NewQueueEntity("invoices")
+			must(t, ns.CreateQueue("invoices"))
+			must(t, ns.SetDuplicateDetection("invoices", 10*time.Minute))
+
+			// The message was accepted by the queue shortly before it was dead-lettered
+			must(t, ns.Send("invoices", message("a")))
+			must(t, ns.AddDeadLetter(queue, message("a"), "reason", "description"))
+
+			overrides, err := io.ParseMessageOverrides(test.strip, nil)
+			must(t, err)
+
+			client := topics.NewClientWithBackend(ns.Name(), ns, ns)
+			_, err = client.ResendDLQMessages(context.Background(), queue, &topics.ResendOptions{Overrides: overrides})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ResendDLQMessages() error = %v, want error %v", err, test.wantErr)
+			}
+
+			assertCount(t, ns, queue, false, test.wantActive)
+			assertCount(t, ns, queue, true, test.wantDLQ)
+		})
+	}
+}
+
+func message(id string) *azservicebus.Message {
+	return &azservicebus.Message{MessageID: &id, Body: []byte(id)}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertCount(t *testing.T, ns *memory.Namespace, entity topics.Entity, deadLetter bool, want int) {
+	t.Helper()
+
+	messages, err := ns.Messages(entity, deadLetter)
+	must(t, err)
+
+	if len(messages) != want {
+		t.Errorf("%s has %d messages (dead-letter %v), want %d", entity, len(messages), deadLetter, want)
+	}
+}
@@ -2,103 +2,120 @@ package topics
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+	"os"
 )
 
-func FetchTopics(connStr string) ([]string, error) {
-	client, err := admin.NewClientFromConnectionString(connStr, nil)
+func (c *Client) FetchTopics(ctx context.Context) ([]string, error) {
+	topics, err := c.admin.ListTopics(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("could not create service bus admin client: %w", err)
-	}
-
-	ctx := context.Background()
-	pager := client.NewListTopicsPager(nil)
-
-	var topics []string
-	for pager.More() {
-		page, err := pager.NextPage(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("could not fetch topics page: %w", err)
-		}
-		for _, topic := range page.Topics {
-			topics = append(topics, topic.TopicName)
-		}
+		return nil, fmt.Errorf("could not fetch topics: %w", err)
 	}
 
 	return topics, nil
 }
 
-func FetchTopicStats(connStr string, topic string) (*admin.TopicRuntimeProperties, error) {
-	client, err := admin.NewClientFromConnectionString(connStr, nil)
+func (c *Client) FetchTopicStats(ctx context.Context, topic string) (*admin.TopicRuntimeProperties, error) {
+	topicProps, err := c.admin.GetTopicRuntimeProperties(ctx, topic)
 	if err != nil {
-		return nil, fmt.Errorf("could not create service bus admin client: %w", err)
+		return nil, fmt.Errorf("could not fetch topic runtime properties: %w", err)
 	}
 
-	ctx := context.Background()
-	topicProps, err := client.GetTopicRuntimeProperties(ctx, topic, nil)
+	return topicProps, nil
+}
+
+func (c *Client) FetchTopicSubscriptions(ctx context.Context, topic string) ([]string, error) {
+	subscriptions, err := c.admin.ListSubscriptions(ctx, topic)
 	if err != nil {
-		return nil, fmt.Errorf("could not fetch topic runtime properties: %w", err)
+		return nil, fmt.Errorf("could not fetch subscriptions: %w", err)
 	}
 
-	return &topicProps.TopicRuntimeProperties, nil
+	return subscriptions, nil
 }
 
-func FetchTopicSubscriptions(connStr string, topic string) ([]string, error) {
-	client, err := admin.NewClientFromConnectionString(connStr, nil)
+func (c *Client) FetchTopicSubscriptionStats(ctx context.Context, topic string, subscription string) (*admin.SubscriptionRuntimeProperties, error) {
+	subscriptionProps, err := c.admin.GetSubscriptionRuntimeProperties(ctx, topic, subscription)
 	if err != nil {
-		return nil, fmt.Errorf("could not create service bus admin client: %w", err)
+		return nil, fmt.Errorf("could not fetch subscription runtime properties: %w", err)
 	}
 
-	ctx := context.Background()
-	pager := client.NewListSubscriptionsPager(topic, nil)
+	return subscriptionProps, nil
+}
 
-	var subscriptions []string
-	for pager.More() {
-		page, err := pager.NextPage(ctx)
+func (c *Client) GetDLQMessageCount(ctx context.Context, entity Entity) (int, error) {
+	if entity.Kind == EntityKindQueue {
+		queueProps, err := c.FetchQueueStats(ctx, entity.Queue)
 		if err != nil {
-			return nil, fmt.Errorf("could not fetch subscriptions page: %w", err)
+			return 0, err
 		}
-		for _, subscription := range page.Subscriptions {
-			subscriptions = append(subscriptions, subscription.SubscriptionName)
-		}
-	}
 
-	return subscriptions, nil
-}
-
-func FetchTopicSubscriptionStats(connStr string, topic string, subscription string) (*admin.SubscriptionRuntimeProperties, error) {
-	client, err := admin.NewClientFromConnectionString(connStr, nil)
-	if err != nil {
-		return nil, fmt.Errorf("could not create service bus admin client: %w", err)
+		return int(queueProps.DeadLetterMessageCount), nil
 	}
 
-	ctx := context.Background()
-	subscriptionProps, err := client.GetSubscriptionRuntimeProperties(ctx, topic, subscription, nil)
+	subscriptionProps, err := c.FetchTopicSubscriptionStats(ctx, entity.Topic, entity.Subscription)
 	if err != nil {
-		return nil, fmt.Errorf("could not fetch subscription runtime properties: %w", err)
+		return 0, err
 	}
 
-	return &subscriptionProps.SubscriptionRuntimeProperties, nil
+	return int(subscriptionProps.DeadLetterMessageCount), nil
 }
 
-func GetDLQMessageCount(connStr string, topic string, subscription string) (int, error) {
-	client, err := admin.NewClientFromConnectionString(connStr, nil)
-	if err != nil {
-		return 0, fmt.Errorf("could not create service bus admin client: %w", err)
+// ErrInterrupted is returned, wrapping the context error, when an operation stops early because its
+// context was cancelled. Counts returned with it cover the batches that were completed.
+var ErrInterrupted = errors.New("interrupted")
+
+// ArchiveFunc stores a batch of DLQ messages before they are removed from the DLQ. A batch it fails for
+// is abandoned and stays in the DLQ.
+type ArchiveFunc func(messages []*azservicebus.ReceivedMessage) error
+
+func interrupted(ctx context.Context) error {
+	return fmt.Errorf("%w: %w", ErrInterrupted, context.Cause(ctx))
+}
+
+// receiveBatch receives the next batch for a loop that stops between batches. It reports an interruption
+// only when nothing was received, since the SDK returns the messages it already received on cancellation
+// and those have to be processed, in ReceiveAndDelete mode they are gone from the entity.
+func receiveBatch(ctx context.Context, receiver Receiver, maxMessages int) ([]*azservicebus.ReceivedMessage, error) {
+	if ctx.Err() != nil {
+		return nil, interrupted(ctx)
 	}
 
-	ctx := context.Background()
-	subscriptionProps, err := client.GetSubscriptionRuntimeProperties(ctx, topic, subscription, nil)
-	if err != nil {
-		return 0, fmt.Errorf("could not fetch subscription runtime properties: %w", err)
+	messages, err := receiver.ReceiveMessages(ctx, maxMessages, nil)
+	if err != nil && ctx.Err() != nil {
+		return nil, interrupted(ctx)
 	}
 
-	return int(subscriptionProps.DeadLetterMessageCount), nil
+	return messages, err
+}
+
+// printShortReceive reports a DLQ that ran out of messages before the number counted at the start was
+// received.
+func printShortReceive(received int, counted int) {
+	fmt.Fprintf(os.Stderr, "Received %d of the %d messages counted in the DLQ, the others were removed meanwhile or are locked by another receiver\n", received, counted)
 }
 
-func FetchDLQMessages(connStr string, topic string, subscription string, receiveMode azservicebus.ReceiveMode) (<-chan *azservicebus.ReceivedMessage, <-chan error) {
+type MessageFilter func(*azservicebus.ReceivedMessage) bool
+
+type FetchOptions struct {
+	ReceiveMode azservicebus.ReceiveMode
+	// Filter selects the messages that are fetched. Without ReceiveAndDelete the DLQ is only peeked and
+	// nothing is locked. With it, messages it rejects that were received before the last matching one
+	// are locked during the download and then abandoned, which counts as a delivery.
+	Filter MessageFilter
+}
+
+// FetchDLQMessages streams the DLQ of an entity. In ReceiveAndDelete mode messages are received with a
+// lock and only completed once they have been handed over, so none is lost when the consumer stops.
+// A filtered download that keeps the messages peeks the DLQ instead of receiving it, so nothing is locked.
+// When ctx is cancelled it stops at the next message the consumer has not taken and reports ErrInterrupted.
+func (c *Client) FetchDLQMessages(ctx context.Context, entity Entity, options FetchOptions) (<-chan *azservicebus.ReceivedMessage, <-chan error) {
+	if options.Filter != nil && options.ReceiveMode != azservicebus.ReceiveModeReceiveAndDelete {
+		return c.BrowseMessages(ctx, entity, BrowseOptions{DeadLetter: true, Filter: options.Filter})
+	}
+
 	messageChan := make(chan *azservicebus.ReceivedMessage)
 	errorChan := make(chan error, 1) // Buffered channel for at most one error
 
@@ -106,42 +123,40 @@ func FetchDLQMessages(connStr string, topic string, subscription string, receive
 		defer close(messageChan)
 		defer close(errorChan)
 
-		client, err := azservicebus.NewClientFromConnectionString(connStr, nil)
-		if err != nil {
-			errorChan <- fmt.Errorf("could not create service bus client: %w", err)
-			return
-		}
-
-		receiver, err := client.NewReceiverForSubscription(
-			topic,
-			subscription,
+		// Messages are always received with a lock, so deleted messages are completed only once they have
+		// been handed over and rejected ones can be given back.
+		receiver, err := c.newReceiver(
+			entity,
 			&azservicebus.ReceiverOptions{
 				SubQueue:    azservicebus.SubQueueDeadLetter,
-				ReceiveMode: receiveMode,
+				ReceiveMode: azservicebus.ReceiveModePeekLock,
 			},
 		)
 		if err != nil {
 			errorChan <- fmt.Errorf("could not create receiver for DLQ: %w", err)
 			return
 		}
-		defer receiver.Close(context.Background())
-
-		ctx := context.Background()
+		defer receiver.Close(context.WithoutCancel(ctx))
 
-		dlqMessageCount, err := GetDLQMessageCount(connStr, topic, subscription)
+		dlqMessageCount, err := c.GetDLQMessageCount(ctx, entity)
 		if err != nil {
 			errorChan <- fmt.Errorf("could not fetch DLQ message count: %w", err)
 			return
 		}
 
-		fmt.Printf("Found %d messages in DLQ\n", dlqMessageCount)
+		fmt.Fprintf(os.Stderr, "Found %d messages in DLQ\n", dlqMessageCount)
+
+		if options.ReceiveMode == azservicebus.ReceiveModeReceiveAndDelete {
+			deleteMessages(ctx, receiver, dlqMessageCount, options, messageChan, errorChan)
+			return
+		}
 
 		downloadCount := 0
 		maxBatchSize := 25
 
 		// Now attempt to receive that many messages. Understand that the actual number may vary.
 		for downloadCount < dlqMessageCount {
-			receivedMessages, err := receiver.ReceiveMessages(ctx, maxBatchSize, &azservicebus.ReceiveMessagesOptions{})
+			receivedMessages, err := receiveBatch(ctx, receiver, maxBatchSize)
 			if err != nil {
 				errorChan <- fmt.Errorf("could not receive messages from DLQ: %w", err)
 				return
@@ -150,10 +165,13 @@ func FetchDLQMessages(connStr string, topic string, subscription string, receive
 			downloadCount += len(receivedMessages)
 
 			for _, msg := range receivedMessages {
-				messageChan <- msg
+				if !handOver(ctx, messageChan, msg) {
+					errorChan <- interrupted(ctx)
+					return
+				}
 			}
 
-			fmt.Printf("Downloaded %d messages\n", downloadCount)
+			fmt.Fprintf(os.Stderr, "Downloaded %d messages\n", downloadCount)
 
 			if len(receivedMessages) == 0 {
 				break
@@ -164,171 +182,258 @@ func FetchDLQMessages(connStr string, topic string, subscription string, receive
 	return messageChan, errorChan
 }
 
-func PublishMessagesToTopic(connString string, topic string, messageChan <-chan *azservicebus.Message) error {
-	client, err := azservicebus.NewClientFromConnectionString(connString, nil)
-	if err != nil {
-		return fmt.Errorf("could not create service bus client: %w", err)
+// handOver passes msg to the consumer of messageChan. It gives up and reports false when ctx is cancelled
+// first, so a consumer that stopped reading cannot block the fetch forever.
+func handOver(ctx context.Context, messageChan chan<- *azservicebus.ReceivedMessage, msg *azservicebus.ReceivedMessage) bool {
+	if ctx.Err() != nil {
+		return false
 	}
 
-	sender, err := client.NewSender(topic, nil)
-	if err != nil {
-		return fmt.Errorf("could not create sender for topic: %w", err)
+	select {
+	case messageChan <- msg:
+		return true
+	case <-ctx.Done():
+		return false
 	}
-	defer sender.Close(context.Background())
+}
 
-	ctx := context.Background()
+// deleteMessages removes the messages of a DLQ that match the filter of options, or all of them without
+// one, through a peek-lock receiver. Each message is completed once it has been handed over. With a filter
+// the DLQ is peeked first, so nothing is received when no message matches and receiving stops once every
+// matching message has been deleted. Messages received before that which do not match stay locked until
+// the scan is over, so they are not received twice, and are then abandoned. Their locks are renewed during
+// a long scan, and messages whose locks are lost anyway are recognised by their sequence numbers.
+func deleteMessages(ctx context.Context, receiver Receiver, dlqMessageCount int, options FetchOptions, messageChan chan<- *azservicebus.ReceivedMessage, errorChan chan<- error) {
+	matches := options.Filter
+	if matches == nil {
+		matches = func(*azservicebus.ReceivedMessage) bool { return true }
+	}
 
-	batch, err := sender.NewMessageBatch(ctx, &azservicebus.MessageBatchOptions{})
-	if err != nil {
-		return fmt.Errorf("could not create message batch: %w", err)
+	// pending holds the sequence numbers of the matching messages not deleted yet; nil means unknown
+	var pending map[int64]bool
+
+	if options.Filter != nil {
+		var err error
+		pending, err = peekMatching(ctx, receiver, options.Filter)
+		if err != nil {
+			errorChan <- err
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "%d messages match\n", len(pending))
 	}
 
-	sentItems := 0
+	seenSequenceNumbers := make(map[int64]bool)
 
-	for msg := range messageChan {
-		if err := batch.AddMessage(msg, &azservicebus.AddMessageOptions{}); err != nil {
-			return fmt.Errorf("could not add message to batch: %w", err)
+	// Settling finishes the current batch and gives held messages back even after an interruption
+	settleCtx := context.WithoutCancel(ctx)
+
+	heldMessages := holdMessages(settleCtx, receiver)
+	defer heldMessages.release()
+
+	scannedCount := 0
+	deletedCount := 0
+	maxBatchSize := 25
+
+	for scannedCount < dlqMessageCount && (pending == nil || len(pending) > 0) {
+		receivedMessages, err := receiveBatch(ctx, receiver, maxBatchSize)
+		if err != nil {
+			errorChan <- fmt.Errorf("could not receive messages from DLQ: %w", err)
+			return
 		}
 
-		if batch.NumMessages() == 100 {
-			if err := sender.SendMessageBatch(ctx, batch, &azservicebus.SendMessageBatchOptions{}); err != nil {
-				return fmt.Errorf("could not send message batch: %w", err)
+		newCount := 0
+
+		for _, msg := range receivedMessages {
+			if msg.SequenceNumber != nil {
+				if seenSequenceNumbers[*msg.SequenceNumber] {
+					heldMessages.add(msg)
+					continue
+				}
+				seenSequenceNumbers[*msg.SequenceNumber] = true
 			}
 
-			sentItems += int(batch.NumMessages())
-			fmt.Printf("Sent %d messages\n", sentItems)
+			newCount++
 
-			batch, err = sender.NewMessageBatch(ctx, &azservicebus.MessageBatchOptions{})
-			if err != nil {
-				return fmt.Errorf("could not create message batch: %w", err)
+			if !matches(msg) {
+				heldMessages.add(msg)
+				continue
 			}
-		}
-	}
 
-	if batch.NumMessages() > 0 {
-		if err := sender.SendMessageBatch(ctx, batch, &azservicebus.SendMessageBatchOptions{}); err != nil {
-			return fmt.Errorf("could not send message batch: %w", err)
+			if !handOver(ctx, messageChan, msg) {
+				// The message was not taken, so it is given back with the held ones
+				heldMessages.add(msg)
+				errorChan <- interrupted(ctx)
+				return
+			}
+
+			if err := receiver.CompleteMessage(settleCtx, msg, nil); err != nil {
+				errorChan <- fmt.Errorf("could not delete message %s from DLQ: %w", msg.MessageID, err)
+				return
+			}
+
+			deletedCount++
+			if msg.SequenceNumber != nil {
+				delete(pending, *msg.SequenceNumber)
+			}
 		}
 
-		sentItems += int(batch.NumMessages())
-		fmt.Printf("Sent %d messages\n", sentItems)
-	}
+		if len(receivedMessages) == 0 {
+			break
+		}
 
-	return nil
+		if newCount == 0 {
+			// Only messages whose locks were lost came back, the rest of the DLQ comes after them
+			continue
+		}
 
-}
+		scannedCount += newCount
 
-func ResendDLQMessages(connStr string, topic string, subscription string) (int, error) {
-	client, err := azservicebus.NewClientFromConnectionString(connStr, nil)
-	if err != nil {
-		return 0, fmt.Errorf("could not create service bus client: %w", err)
+		fmt.Fprintf(os.Stderr, "Scanned %d messages, %d deleted\n", scannedCount, deletedCount)
 	}
 
-	receiver, err := client.NewReceiverForSubscription(
-		topic,
-		subscription,
-		&azservicebus.ReceiverOptions{
-			SubQueue:    azservicebus.SubQueueDeadLetter,
-			ReceiveMode: azservicebus.ReceiveModeReceiveAndDelete,
-		},
-	)
-	if err != nil {
-		return 0, fmt.Errorf("could not create receiver for DLQ: %w", err)
+	if len(pending) > 0 {
+		fmt.Fprintf(os.Stderr, "%d matching messages were not received, they were removed meanwhile or are locked by another receiver\n", len(pending))
+	} else if pending == nil && scannedCount < dlqMessageCount {
+		printShortReceive(scannedCount, dlqMessageCount)
 	}
-	defer receiver.Close(context.Background())
+}
 
-	sender, err := client.NewSender(topic, nil)
-	if err != nil {
-		return 0, fmt.Errorf("could not create sender for topic: %w", err)
+// peekMatching pages through a DLQ with PeekMessages and returns the sequence numbers of the messages
+// filter accepts, without locking anything.
+func peekMatching(ctx context.Context, receiver Receiver, filter MessageFilter) (map[int64]bool, error) {
+	matching := make(map[int64]bool)
+	var fromSequenceNumber int64
+	maxPageSize := 100
+
+	for {
+		if ctx.Err() != nil {
+			return nil, interrupted(ctx)
+		}
+
+		peekedMessages, err := receiver.PeekMessages(ctx, maxPageSize, &azservicebus.PeekMessagesOptions{
+			FromSequenceNumber: &fromSequenceNumber,
+		})
+		if err != nil && ctx.Err() != nil {
+			return nil, interrupted(ctx)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not peek messages: %w", err)
+		}
+
+		if len(peekedMessages) == 0 {
+			return matching, nil
+		}
+
+		for _, msg := range peekedMessages {
+			if msg.SequenceNumber != nil && filter(msg) {
+				matching[*msg.SequenceNumber] = true
+			}
+		}
+
+		fromSequenceNumber = *peekedMessages[len(peekedMessages)-1].SequenceNumber + 1
 	}
-	defer sender.Close(context.Background())
+}
 
-	ctx := context.Background()
+// PublishBatchSize is how many messages PublishMessages sends at a time.
+const PublishBatchSize = 100
 
-	dlqMessageCount, err := GetDLQMessageCount(connStr, topic, subscription)
+// PublishMessages sends the messages in batches of 100 and returns how many were sent. When ctx is
+// cancelled it stops after the last full batch, so exactly the first messages up to the returned count
+// have been published. sent, when given, is called with the messages of every batch once it has been sent.
+func (c *Client) PublishMessages(ctx context.Context, queueOrTopic string, messageChan <-chan *azservicebus.Message, sent func(messages []*azservicebus.Message)) (int, error) {
+	sender, err := c.messaging.NewSender(queueOrTopic)
 	if err != nil {
-		return 0, fmt.Errorf("could not fetch DLQ message count: %w", err)
+		return 0, fmt.Errorf("could not create sender for %s: %w", queueOrTopic, err)
 	}
+	defer sender.Close(context.WithoutCancel(ctx))
 
-	if dlqMessageCount == 0 {
-		return 0, nil
+	// A batch that is being sent is finished even after an interruption
+	sendCtx := context.WithoutCancel(ctx)
+
+	batch, err := sender.NewMessageBatch(sendCtx, &azservicebus.MessageBatchOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("could not create message batch: %w", err)
 	}
 
-	processedCount := 0
-	maxBatchSize := 25
+	sentItems := 0
+	var batchMessages []*azservicebus.Message
 
-	for processedCount < dlqMessageCount {
-		receivedMessages, err := receiver.ReceiveMessages(ctx, maxBatchSize, nil)
-		if err != nil {
-			return processedCount, fmt.Errorf("could not receive messages from DLQ: %w", err)
+	for msg := range messageChan {
+		if ctx.Err() != nil {
+			return sentItems, interrupted(ctx)
 		}
 
-		if len(receivedMessages) == 0 {
-			break
+		if err := batch.AddMessage(msg, &azservicebus.AddMessageOptions{}); err != nil {
+			return sentItems, fmt.Errorf("could not add message to batch: %w", err)
 		}
+		batchMessages = append(batchMessages, msg)
 
-		batch, err := sender.NewMessageBatch(ctx, nil)
-		if err != nil {
-			return processedCount, fmt.Errorf("could not create message batch: %w", err)
-		}
+		if batch.NumMessages() == PublishBatchSize {
+			if err := sender.SendMessageBatch(sendCtx, batch, &azservicebus.SendMessageBatchOptions{}); err != nil {
+				return sentItems, fmt.Errorf("could not send message batch: %w", err)
+			}
 
-		for _, msg := range receivedMessages {
-			newMsg := &azservicebus.Message{
-				Body:                  msg.Body,
-				Subject:               msg.Subject,
-				CorrelationID:         msg.CorrelationID,
-				ApplicationProperties: msg.ApplicationProperties,
+			sentItems += int(batch.NumMessages())
+			fmt.Fprintf(os.Stderr, "Sent %d messages\n", sentItems)
+
+			if sent != nil {
+				sent(batchMessages)
 			}
+			batchMessages = nil
 
-			if err := batch.AddMessage(newMsg, nil); err != nil {
-				// If batch is full, send it and create a new one
-				if err := sender.SendMessageBatch(ctx, batch, nil); err != nil {
-					return processedCount, fmt.Errorf("could not send message batch: %w", err)
-				}
-				batch, err = sender.NewMessageBatch(ctx, nil)
-				if err != nil {
-					return processedCount, fmt.Errorf("could not create message batch: %w", err)
-				}
-				if err := batch.AddMessage(newMsg, nil); err != nil {
-					return processedCount, fmt.Errorf("could not add message to batch: %w", err)
-				}
+			batch, err = sender.NewMessageBatch(sendCtx, &azservicebus.MessageBatchOptions{})
+			if err != nil {
+				return sentItems, fmt.Errorf("could not create message batch: %w", err)
 			}
 		}
+	}
 
-		if batch.NumMessages() > 0 {
-			if err := sender.SendMessageBatch(ctx, batch, nil); err != nil {
-				return processedCount, fmt.Errorf("could not send message batch: %w", err)
-			}
+	if ctx.Err() != nil {
+		return sentItems, interrupted(ctx)
+	}
+
+	if batch.NumMessages() > 0 {
+		if err := sender.SendMessageBatch(sendCtx, batch, &azservicebus.SendMessageBatchOptions{}); err != nil {
+			return sentItems, fmt.Errorf("could not send message batch: %w", err)
 		}
 
-		processedCount += len(receivedMessages)
+		sentItems += int(batch.NumMessages())
+		fmt.Fprintf(os.Stderr, "Sent %d messages\n", sentItems)
+
+		if sent != nil {
+			sent(batchMessages)
+		}
 	}
 
-	return processedCount, nil
+	return sentItems, nil
+
 }
 
-func ClearDLQMessages(connStr string, topic string, subscription string) (int, error) {
-	client, err := azservicebus.NewClientFromConnectionString(connStr, nil)
-	if err != nil {
-		return 0, fmt.Errorf("could not create service bus client: %w", err)
-	}
+type ClearOptions struct {
+	// Archive stores every batch of DLQ messages before it is deleted.
+	Archive ArchiveFunc
+	// Cleared is called with the messages of every batch that have been deleted from the DLQ.
+	Cleared func(messages []*azservicebus.ReceivedMessage)
+}
 
-	receiver, err := client.NewReceiverForSubscription(
-		topic,
-		subscription,
+// ClearDLQMessages deletes the dead-lettered messages of an entity and returns how many were deleted.
+// Messages are received in peek-lock mode and only completed once the archive, when given, has stored them.
+// When ctx is cancelled the batch in flight is still archived and completed before ErrInterrupted is returned.
+func (c *Client) ClearDLQMessages(ctx context.Context, entity Entity, options ClearOptions) (int, error) {
+	receiver, err := c.newReceiver(
+		entity,
 		&azservicebus.ReceiverOptions{
 			SubQueue:    azservicebus.SubQueueDeadLetter,
-			ReceiveMode: azservicebus.ReceiveModeReceiveAndDelete,
+			ReceiveMode: azservicebus.ReceiveModePeekLock,
 		},
 	)
 	if err != nil {
 		return 0, fmt.Errorf("could not create receiver for DLQ: %w", err)
 	}
-	defer receiver.Close(context.Background())
-
-	ctx := context.Background()
+	defer receiver.Close(context.WithoutCancel(ctx))
 
-	dlqMessageCount, err := GetDLQMessageCount(connStr, topic, subscription)
+	dlqMessageCount, err := c.GetDLQMessageCount(ctx, entity)
 	if err != nil {
 		return 0, fmt.Errorf("could not fetch DLQ message count: %w", err)
 	}
@@ -337,13 +442,17 @@ func ClearDLQMessages(connStr string, topic string, subscription string) (int, e
 		return 0, nil
 	}
 
+	// Archiving and completing a received batch is finished even after an interruption
+	batchCtx := context.WithoutCancel(ctx)
+
 	processedCount := 0
+	clearedCount := 0
 	maxBatchSize := 25
 
 	for processedCount < dlqMessageCount {
-		receivedMessages, err := receiver.ReceiveMessages(ctx, maxBatchSize, nil)
+		receivedMessages, err := receiveBatch(ctx, receiver, maxBatchSize)
 		if err != nil {
-			return processedCount, fmt.Errorf("could not receive messages from DLQ: %w", err)
+			return clearedCount, fmt.Errorf("could not receive messages from DLQ: %w", err)
 		}
 
 		if len(receivedMessages) == 0 {
@@ -351,9 +460,36 @@ func ClearDLQMessages(connStr string, topic string, subscription string) (int, e
 		}
 
 		processedCount += len(receivedMessages)
+
+		if options.Archive != nil {
+			if err := options.Archive(receivedMessages); err != nil {
+				for _, msg := range receivedMessages {
+					_ = receiver.AbandonMessage(batchCtx, msg, nil)
+				}
+
+				return clearedCount, fmt.Errorf("could not archive messages, they were left in the DLQ: %w", err)
+			}
+		}
+
+		var clearedMessages []*azservicebus.ReceivedMessage
+
+		for _, msg := range receivedMessages {
+			if err := receiver.CompleteMessage(batchCtx, msg, nil); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not complete message %s: %v\n", msg.MessageID, err)
+				continue
+			}
+
+			clearedMessages = append(clearedMessages, msg)
+		}
+
+		clearedCount += len(clearedMessages)
+
+		if options.Cleared != nil && len(clearedMessages) > 0 {
+			options.Cleared(clearedMessages)
+		}
 	}
 
-	return processedCount, nil
+	return clearedCount, nil
 }
 
 func min(a, b int) int {
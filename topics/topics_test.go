@@ -0,0 +1,179 @@
+package topics_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"service-bus-hero/topics"
+	"service-bus-hero/topics/memory"
+	"slices"
+	"testing"
+)
+
+func TestClearDLQMessages(t *testing.T) {
+	tests := []struct {
+		name        string
+		dlqMessages int
+		archiveErr  error
+		wantErr     bool
+		wantCleared int
+		wantDLQ     int
+	}{
+		{"empty DLQ", 0, nil, false, 0, 0},
+		{"several batches", 60, nil, false, 60, 0},
+		{"archive failure", 60, errors.New("disk full"), true, 0, 60},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := memory.New("test")
+			queue := topics.NewQueueEntity("invoices")
+			must(t, ns.CreateQueue("invoices"))
+			must(t, ns.Send("invoices", message("active")))
+			addDeadLetters(t, ns, queue, test.dlqMessages)
+
+			var archived, cleared []string
+			options := topics.ClearOptions{
+				Archive: func(messages []*azservicebus.ReceivedMessage) error {
+					archived = append(archived, messageIDs(messages)...)
+					return test.archiveErr
+				},
+				Cleared: func(messages []*azservicebus.ReceivedMessage) {
+					cleared = append(cleared, messageIDs(messages)...)
+				},
+			}
+
+			client := topics.NewClientWithBackend(ns.Name(), ns, ns)
+			count, err := client.ClearDLQMessages(context.Background(), queue, options)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ClearDLQMessages() error = %v, want error %v", err, test.wantErr)
+			}
+
+			if count != test.wantCleared || len(cleared) != test.wantCleared {
+				t.Errorf("ClearDLQMessages() = %d and Cleared was called with %d messages, want %d", count, len(cleared), test.wantCleared)
+			}
+
+			// Every cleared message was archived first, and nothing else was
+			if !test.wantErr && !slices.Equal(archived, cleared) {
+				t.Errorf("archived %v, want the cleared messages %v", archived, cleared)
+			}
+			for i, id := range cleared {
+				if want := fmt.Sprintf("m%d", i); id != want {
+					t.Errorf("message %d cleared is %s, want %s", i, id, want)
+					break
+				}
+			}
+
+			assertCount(t, ns, queue, true, test.wantDLQ)
+			assertCount(t, ns, queue, false, 1)
+
+			// Messages that could not be archived were given back and can be received right away
+			receiver, err := ns.NewReceiver(queue, &azservicebus.ReceiverOptions{SubQueue: azservicebus.SubQueueDeadLetter})
+			must(t, err)
+			received, err := receiver.ReceiveMessages(context.Background(), 100, nil)
+			must(t, err)
+			if len(received) != test.wantDLQ {
+				t.Errorf("received %d messages from the DLQ after clearing, want %d", len(received), test.wantDLQ)
+			}
+		})
+	}
+}
+
+func TestFetchDLQMessagesFiltered(t *testing.T) {
+	// Every third of the 60 messages matches, spread over several receive batches
+	matches := func(msg *azservicebus.ReceivedMessage) bool {
+		return msg.ApplicationProperties["match"] == true
+	}
+	none := func(*azservicebus.ReceivedMessage) bool { return false }
+
+	tests := []struct {
+		name          string
+		receiveMode   azservicebus.ReceiveMode
+		filter        topics.MessageFilter
+		wantFetched   int
+		wantDLQ       int
+		wantDelivered int
+	}{
+		{"peek-lock only peeks", azservicebus.ReceiveModePeekLock, matches, 20, 60, 0},
+		{"receive-and-delete removes matched messages", azservicebus.ReceiveModeReceiveAndDelete, matches, 20, 40, 40},
+		{"receive-and-delete without a match receives nothing", azservicebus.ReceiveModeReceiveAndDelete, none, 0, 60, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := memory.New("test")
+			subscription := topics.NewSubscriptionEntity("orders", "billing")
+			must(t, ns.CreateTopic("orders"))
+			must(t, ns.CreateSubscription("orders", "billing"))
+			addDeadLetters(t, ns, subscription, 60)
+
+			client := topics.NewClientWithBackend(ns.Name(), ns, ns)
+			messageChan, errorChan := client.FetchDLQMessages(context.Background(), subscription, topics.FetchOptions{
+				ReceiveMode: test.receiveMode,
+				Filter:      test.filter,
+			})
+
+			fetched := make(map[string]bool)
+			for msg := range messageChan {
+				if !test.filter(msg) {
+					t.Errorf("fetched message %s the filter rejects", msg.MessageID)
+				}
+				if fetched[msg.MessageID] {
+					t.Errorf("fetched message %s twice", msg.MessageID)
+				}
+				fetched[msg.MessageID] = true
+			}
+			must(t, <-errorChan)
+
+			if len(fetched) != test.wantFetched {
+				t.Errorf("fetched %d messages, want %d", len(fetched), test.wantFetched)
+			}
+
+			assertCount(t, ns, subscription, true, test.wantDLQ)
+
+			receiver, err := ns.NewReceiver(subscription, &azservicebus.ReceiverOptions{SubQueue: azservicebus.SubQueueDeadLetter})
+			must(t, err)
+
+			// Only messages that were received to get past them count a delivery
+			peeked, err := receiver.PeekMessages(context.Background(), 100, nil)
+			must(t, err)
+			delivered := 0
+			for _, msg := range peeked {
+				if msg.DeliveryCount > 0 {
+					delivered++
+				}
+			}
+			if delivered != test.wantDelivered {
+				t.Errorf("%d messages left in the DLQ were delivered, want %d", delivered, test.wantDelivered)
+			}
+
+			// Messages left in the DLQ are unlocked again and can be received right away
+			received, err := receiver.ReceiveMessages(context.Background(), 100, nil)
+			must(t, err)
+			if len(received) != test.wantDLQ {
+				t.Errorf("received %d messages from the DLQ after fetching, want %d", len(received), test.wantDLQ)
+			}
+		})
+	}
+}
+
+func messageIDs(messages []*azservicebus.ReceivedMessage) []string {
+	var ids []string
+	for _, msg := range messages {
+		ids = append(ids, msg.MessageID)
+	}
+	return ids
+}
+
+// addDeadLetters dead-letters count messages on entity, marking every third one with the application
+// property match.
+func addDeadLetters(t *testing.T, ns *memory.Namespace, entity topics.Entity, count int) {
+	t.Helper()
+
+	for i := 0; i < count; i++ {
+		msg := message(fmt.Sprintf("m%d", i))
+		msg.ApplicationProperties = map[string]any{"match": i%3 == 0}
+		must(t, ns.AddDeadLetter(entity, msg, "reason", "description"))
+	}
+}